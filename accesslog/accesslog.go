@@ -0,0 +1,171 @@
+package accesslog
+
+import (
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	httpserver "github.com/wasmcloud/interfaces/httpserver/tinygo"
+
+	logging "github.com/taction/http-provider-go/log"
+)
+
+const (
+	Inbound  = "inbound"
+	Outbound = "outbound"
+
+	// CallerAppIDHeader is the app id of the caller, set by dapr sidecars which send it.
+	CallerAppIDHeader = "dapr-caller-app-id"
+	traceparentHeader = "traceparent"
+
+	redacted = "[redacted]"
+)
+
+// DefaultRedactHeaders are always redacted when headers are logged.
+var DefaultRedactHeaders = []string{"authorization", "proxy-authorization", "cookie", "set-cookie", "dapr-api-token"}
+
+var log = logging.NewLogger("wasmcloud.access")
+
+// Options configure the access log.
+type Options struct {
+	Enabled bool
+	// SampleRate is the fraction of successful calls logged, failed calls are always logged.
+	SampleRate float64
+	// Headers logs the request headers, with the values of RedactHeaders and DefaultRedactHeaders replaced.
+	Headers       bool
+	RedactHeaders []string
+	// BodyLimit logs up to BodyLimit bytes of the bodies, 0 logs none.
+	BodyLimit int
+}
+
+// Entry is one invocation.
+type Entry struct {
+	Direction   string
+	Protocol    string
+	CallerAppID string
+	TargetAppID string
+	Method      string
+	Path        string
+	Status      int
+	Latency     time.Duration
+	// RequestBytes and ResponseBytes are the sizes of the bodies.
+	RequestBytes  int
+	ResponseBytes int
+	TraceID       string
+	Headers       map[string][]string
+	RequestBody   []byte
+	ResponseBody  []byte
+	Err           error
+}
+
+var (
+	l       sync.RWMutex
+	options Options
+	redact  map[string]bool
+)
+
+// Apply replaces the options of the access log.
+func Apply(opts Options) {
+	r := map[string]bool{}
+	for _, h := range append(append([]string{}, DefaultRedactHeaders...), opts.RedactHeaders...) {
+		r[strings.ToLower(h)] = true
+	}
+	l.Lock()
+	options, redact = opts, r
+	l.Unlock()
+}
+
+// Enabled tells whether calls are logged, to skip building entries otherwise.
+func Enabled() bool {
+	l.RLock()
+	defer l.RUnlock()
+	return options.Enabled
+}
+
+// Log writes e when the access log is enabled and e is sampled.
+func Log(e Entry) {
+	l.RLock()
+	opts, r := options, redact
+	l.RUnlock()
+	failed := e.Err != nil || e.Status >= 500
+	if !opts.Enabled || !failed && opts.SampleRate < 1 && rand.Float64() >= opts.SampleRate {
+		return
+	}
+
+	fields := map[string]any{
+		"direction":      e.Direction,
+		"protocol":       e.Protocol,
+		"caller_app_id":  e.CallerAppID,
+		"target_app_id":  e.TargetAppID,
+		"method":         e.Method,
+		"path":           e.Path,
+		"status":         e.Status,
+		"latency_ms":     float64(e.Latency.Microseconds()) / 1000,
+		"request_bytes":  e.RequestBytes,
+		"response_bytes": e.ResponseBytes,
+	}
+	if e.TraceID != "" {
+		fields["trace_id"] = e.TraceID
+	}
+	if opts.Headers && len(e.Headers) > 0 {
+		headers := make(map[string]string, len(e.Headers))
+		for k, v := range e.Headers {
+			if r[strings.ToLower(k)] {
+				headers[k] = redacted
+			} else {
+				headers[k] = strings.Join(v, ",")
+			}
+		}
+		fields["headers"] = headers
+	}
+	if opts.BodyLimit > 0 {
+		fields["request_body"] = truncate(e.RequestBody, opts.BodyLimit)
+		fields["response_body"] = truncate(e.ResponseBody, opts.BodyLimit)
+	}
+	if e.Err != nil {
+		fields["error"] = e.Err.Error()
+		log.WithFields(fields).Warn("access")
+		return
+	}
+	log.WithFields(fields).Info("access")
+}
+
+// TraceID returns the trace id of the w3c traceparent header in headers.
+func TraceID(headers map[string][]string) string {
+	for k, v := range headers {
+		if strings.EqualFold(k, traceparentHeader) && len(v) > 0 {
+			// version-traceid-spanid-flags
+			if parts := strings.Split(v[0], "-"); len(parts) == 4 {
+				return parts[1]
+			}
+		}
+	}
+	return ""
+}
+
+// Header returns the first value of the header name in headers, whatever its case.
+func Header(headers map[string][]string, name string) string {
+	for k, v := range headers {
+		if strings.EqualFold(k, name) && len(v) > 0 {
+			return v[0]
+		}
+	}
+	return ""
+}
+
+func truncate(b []byte, limit int) string {
+	if len(b) > limit {
+		return string(b[:limit]) + "..."
+	}
+	return string(b)
+}
+
+// FromHeaderMap converts the headers of an actor request or response.
+func FromHeaderMap(h httpserver.HeaderMap) map[string][]string {
+	headers := make(map[string][]string, len(h))
+	for k, v := range h {
+		headers[k] = v
+	}
+	return headers
+}
@@ -0,0 +1,115 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	logging "github.com/taction/http-provider-go/log"
+)
+
+// capture writes the access log as json to a file and returns a function reading its entries.
+func capture(t *testing.T) func() []map[string]interface{} {
+	path := filepath.Join(t.TempDir(), "access.log")
+	opts := logging.DefaultOptions()
+	opts.Format, opts.Output = logging.FormatJSON, path
+	require.NoError(t, logging.Apply(opts))
+	t.Cleanup(func() {
+		_ = logging.Apply(logging.DefaultOptions())
+		Apply(Options{})
+	})
+	return func() []map[string]interface{} {
+		b, err := os.ReadFile(path)
+		require.NoError(t, err)
+		var entries []map[string]interface{}
+		for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+			if line == "" {
+				continue
+			}
+			var entry map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(line), &entry))
+			entries = append(entries, entry)
+		}
+		return entries
+	}
+}
+
+func TestLog(t *testing.T) {
+	read := capture(t)
+	Apply(Options{Enabled: true, SampleRate: 1, Headers: true, RedactHeaders: []string{"X-Token"}, BodyLimit: 4})
+	headers := map[string][]string{
+		"Authorization": {"Bearer secret"},
+		"x-token":       {"secret"},
+		"Content-Type":  {"application/json"},
+		"Traceparent":   {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+	}
+	Log(Entry{
+		Direction:     Inbound,
+		Protocol:      "http",
+		CallerAppID:   "caller",
+		TargetAppID:   "target",
+		Method:        "POST",
+		Path:          "/orders",
+		Status:        200,
+		Latency:       1500 * time.Microsecond,
+		RequestBytes:  10,
+		ResponseBytes: 2,
+		TraceID:       TraceID(headers),
+		Headers:       headers,
+		RequestBody:   []byte("0123456789"),
+		ResponseBody:  []byte("ok"),
+	})
+
+	entries := read()
+	require.Len(t, entries, 1)
+	e := entries[0]
+	assert.Equal(t, "access", e["msg"])
+	assert.Equal(t, "info", e["level"])
+	assert.Equal(t, "wasmcloud.access", e["scope"])
+	assert.Equal(t, "caller", e["caller_app_id"])
+	assert.Equal(t, "target", e["target_app_id"])
+	assert.Equal(t, 1.5, e["latency_ms"])
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", e["trace_id"])
+	assert.Equal(t, map[string]interface{}{
+		"Authorization": redacted,
+		"x-token":       redacted,
+		"Content-Type":  "application/json",
+		"Traceparent":   "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	}, e["headers"])
+	assert.Equal(t, "0123...", e["request_body"])
+	assert.Equal(t, "ok", e["response_body"])
+}
+
+func TestLogSampling(t *testing.T) {
+	read := capture(t)
+	Apply(Options{Enabled: true, SampleRate: 0})
+	Log(Entry{Path: "/ok", Status: 200})
+	Log(Entry{Path: "/unavailable", Status: 503})
+	Log(Entry{Path: "/failed", Err: errors.New("connection refused")})
+
+	entries := read()
+	require.Len(t, entries, 2)
+	assert.Equal(t, "/unavailable", entries[0]["path"])
+	assert.Nil(t, entries[0]["headers"])
+	assert.Nil(t, entries[0]["request_body"])
+	assert.Equal(t, "warning", entries[1]["level"])
+	assert.Equal(t, "connection refused", entries[1]["error"])
+
+	Apply(Options{SampleRate: 1})
+	Log(Entry{Path: "/failed", Err: errors.New("connection refused")})
+	assert.Len(t, read(), 2)
+}
+
+func TestHeader(t *testing.T) {
+	headers := map[string][]string{"Dapr-Caller-App-Id": {"caller"}, "traceparent": {"invalid"}}
+	assert.Equal(t, "caller", Header(headers, CallerAppIDHeader))
+	assert.Equal(t, "", Header(headers, "dapr-app-id"))
+	assert.Equal(t, "", TraceID(headers))
+}
@@ -14,7 +14,6 @@ type Echo struct{}
 
 func (e *Echo) HandleRequest(ctx *actor.Context, req httpserver.HttpRequest) (*httpserver.HttpResponse, error) {
 	provider := httpserver.NewProviderHttpServer()
-	req.Header["dapr-app-id"] = []string{"order-processor"} // todo clean req outside
 	req.Body = append([]byte(`{"Proxy": "by wasmcloud", "origin":`), req.Body...)
 	req.Body = append(req.Body, []byte(`}`)...)
 	res, err := provider.HandleRequest(ctx, req)
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/taction/http-provider-go/discovery"
+	"github.com/taction/http-provider-go/discovery/consul"
+)
+
+// adminShutdownTimeout bounds the graceful stop of the admin api.
+const adminShutdownTimeout = 5 * time.Second
+
+// LinkStatus describes a link in the admin api.
+type LinkStatus struct {
+	ActorID string     `json:"actor_id"`
+	Config  LinkConfig `json:"config"`
+	// Registration is what the link is registered as in the name resolution, nil once deregistered.
+	Registration *discovery.App `json:"registration,omitempty"`
+	Healthy      bool           `json:"healthy"`
+	Serving      bool           `json:"serving"`
+}
+
+// ResolverStatus describes the name resolution in the admin api.
+type ResolverStatus struct {
+	Component     string          `json:"component"`
+	Registrations []discovery.App `json:"registrations"`
+	// Cache is the state of the endpoint cache of resolvers which have one.
+	Cache *consul.CacheStats `json:"cache,omitempty"`
+}
+
+// cacheResolver is implemented by resolvers with an endpoint cache.
+type cacheResolver interface {
+	CacheStats() (consul.CacheStats, bool)
+	FlushCache()
+}
+
+// startAdmin serves the admin api when an address is configured.
+func (p *HttpServerProvider) startAdmin() error {
+	c := p.config.Admin
+	if c.Address == "" {
+		return nil
+	}
+	ln, err := net.Listen("tcp", c.Address)
+	if err != nil {
+		return err
+	}
+	p.admin = &http.Server{Handler: p.adminHandler(c.Token), ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := p.admin.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Errorf("admin api stopped: %s", err)
+		}
+	}()
+	log.Infof("admin api listening on %s", ln.Addr())
+	return nil
+}
+
+func (p *HttpServerProvider) stopAdmin() {
+	if p.admin == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), adminShutdownTimeout)
+	defer cancel()
+	_ = p.admin.Shutdown(ctx)
+}
+
+// adminHandler serves the state of the provider as json:
+//
+//	GET  /links                        the links with their configuration and health, rejected ones in rejected
+//	POST /links/{actor_id}/deregister  removes the registration of a link, which keeps serving
+//	GET  /resolver                     the registrations and the cache of the name resolution
+//	POST /resolver/flush               empties the cache of the name resolution
+//	GET  /pool                         the connections to remote apps by address
+//	POST /pool/flush                   builds new connections, the current ones are closed once drained
+func (p *HttpServerProvider) adminHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/links", p.adminLinks)
+	mux.HandleFunc("/links/", p.adminLinkAction)
+	mux.HandleFunc("/resolver", p.adminResolver)
+	mux.HandleFunc("/resolver/flush", p.adminFlushResolver)
+	mux.HandleFunc("/pool", p.adminPool)
+	mux.HandleFunc("/pool/flush", p.adminFlushPool)
+	if token == "" {
+		return mux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+			http.Error(w, "missing or invalid bearer token", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (p *HttpServerProvider) adminLinks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	p.l.Lock()
+	links := make([]LinkStatus, 0, len(p.links))
+	for actorID, l := range p.links {
+		status := LinkStatus{ActorID: actorID, Config: l.values, Healthy: l.checker.Healthy(), Serving: l.serving}
+		if l.app.AppID != "" {
+			app := l.app
+			status.Registration = &app
+		}
+		links = append(links, status)
+	}
+	rejected := make(map[string]string, len(p.rejected))
+	for actorID, err := range p.rejected {
+		rejected[actorID] = err.Error()
+	}
+	p.l.Unlock()
+	sort.Slice(links, func(i, j int) bool { return links[i].ActorID < links[j].ActorID })
+	writeJSON(w, map[string]interface{}{"links": links, "rejected": rejected})
+}
+
+func (p *HttpServerProvider) adminLinkAction(w http.ResponseWriter, r *http.Request) {
+	actorID, action, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/links/"), "/")
+	if !ok || action != "deregister" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := p.deregisterLink(actorID); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deregisterLink removes the registration of a link from the name resolution, the listener keeps serving
+// calls addressed to it directly. Putting the link again with other values registers it again.
+func (p *HttpServerProvider) deregisterLink(actorID string) error {
+	p.l.Lock()
+	defer p.l.Unlock()
+	l, ok := p.links[actorID]
+	if !ok {
+		return fmt.Errorf("no link for actor %s", actorID)
+	}
+	if l.app.AppID != "" {
+		p.nameResolver().RemoveFromDiscovery(l.app)
+		l.app = discovery.App{}
+		log.Warnf("link for actor %s deregistered through the admin api", actorID)
+	}
+	return nil
+}
+
+func (p *HttpServerProvider) adminResolver(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	status := ResolverStatus{Component: p.settings().NameResolution.Component, Registrations: []discovery.App{}}
+	p.l.Lock()
+	for _, l := range p.links {
+		if l.app.AppID != "" {
+			status.Registrations = append(status.Registrations, l.app)
+		}
+	}
+	p.l.Unlock()
+	sort.Slice(status.Registrations, func(i, j int) bool {
+		return status.Registrations[i].InstanceID() < status.Registrations[j].InstanceID()
+	})
+	if c, ok := p.nameResolver().(cacheResolver); ok {
+		if stats, enabled := c.CacheStats(); enabled {
+			status.Cache = &stats
+		}
+	}
+	writeJSON(w, status)
+}
+
+func (p *HttpServerProvider) adminFlushResolver(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if c, ok := p.nameResolver().(cacheResolver); ok {
+		c.FlushCache()
+		log.Infof("name resolution cache flushed through the admin api")
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (p *HttpServerProvider) adminPool(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	p.cl.RLock()
+	pool := p.remoteConns
+	p.cl.RUnlock()
+	writeJSON(w, pool.Stats())
+}
+
+func (p *HttpServerProvider) adminFlushPool(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	p.cl.Lock()
+	old, c := p.swapConnections(), p.config
+	p.cl.Unlock()
+	retireConnections(old, c)
+	log.Infof("remote connections flushed through the admin api")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	provider "github.com/jordan-rash/wasmcloud-provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminHandler(t *testing.T) {
+	var events []string
+	p := newTestProvider(&events)
+	p.ExternalHost = "127.0.0.1"
+	require.NoError(t, p.PutLink(provider.LinkDefinition{ActorID: "MA", Values: map[string]string{"address": "0.0.0.0:8888", "unique_id": "a"}}))
+	p.rejected["MB"] = errors.New("unique_id is required")
+	events = events[:0]
+	h := p.adminHandler("secret")
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("token is required", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/links", nil))
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("links", func(t *testing.T) {
+		w := do(http.MethodGet, "/links")
+		require.Equal(t, http.StatusOK, w.Code)
+		var res struct {
+			Links    []LinkStatus      `json:"links"`
+			Rejected map[string]string `json:"rejected"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		require.Len(t, res.Links, 1)
+		assert.Equal(t, "MA", res.Links[0].ActorID)
+		assert.Equal(t, "a", res.Links[0].Config.AppID)
+		assert.Equal(t, 8888, res.Links[0].Config.Port)
+		assert.Equal(t, "127.0.0.1:8888", res.Links[0].Registration.Address)
+		assert.Equal(t, map[string]string{"MB": "unique_id is required"}, res.Rejected)
+	})
+
+	t.Run("resolver", func(t *testing.T) {
+		w := do(http.MethodGet, "/resolver")
+		require.Equal(t, http.StatusOK, w.Code)
+		var res ResolverStatus
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		assert.Equal(t, "consul", res.Component)
+		require.Len(t, res.Registrations, 1)
+		assert.Nil(t, res.Cache)
+		assert.Equal(t, http.StatusNoContent, do(http.MethodPost, "/resolver/flush").Code)
+	})
+
+	t.Run("deregister", func(t *testing.T) {
+		assert.Equal(t, http.StatusMethodNotAllowed, do(http.MethodGet, "/links/MA/deregister").Code)
+		assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/links/MB/deregister").Code)
+		assert.Equal(t, http.StatusNoContent, do(http.MethodPost, "/links/MA/deregister").Code)
+		assert.Equal(t, []string{"deregister a"}, events)
+		// the link keeps serving
+		assert.Contains(t, p.Actors, "MA")
+
+		// deregistering again does nothing
+		assert.Equal(t, http.StatusNoContent, do(http.MethodPost, "/links/MA/deregister").Code)
+		assert.Len(t, events, 1)
+
+		// putting the link again with other values registers it again
+		events = events[:0]
+		require.NoError(t, p.PutLink(provider.LinkDefinition{ActorID: "MA", Values: map[string]string{"address": "0.0.0.0:8888", "unique_id": "a", "default_target": "b"}}))
+		assert.Equal(t, []string{"register a", "update a"}, events)
+		assert.Equal(t, "a", p.links["MA"].app.AppID)
+	})
+
+	t.Run("pool", func(t *testing.T) {
+		pool := p.remoteConns
+		assert.JSONEq(t, `{}`, do(http.MethodGet, "/pool").Body.String())
+		assert.Equal(t, http.StatusNoContent, do(http.MethodPost, "/pool/flush").Code)
+		assert.NotSame(t, pool, p.remoteConns)
+	})
+}
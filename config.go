@@ -5,7 +5,687 @@
  */
 package main
 
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/taction/http-provider-go/accesslog"
+	"github.com/taction/http-provider-go/discovery"
+	"github.com/taction/http-provider-go/duration"
+	"github.com/taction/http-provider-go/health"
+	"github.com/taction/http-provider-go/loadbalancer"
+	logging "github.com/taction/http-provider-go/log"
+	"github.com/taction/http-provider-go/server"
+)
+
+// configVersion is the latest version of the configuration format.
+const configVersion = 1
+
+// envPrefix prefixes the environment variables overriding the configuration,
+// e.g. HTTP_PROVIDER_REMOTE_DIAL_TIMEOUT for remote.dial_timeout.
+const envPrefix = "HTTP_PROVIDER_"
+
+const (
+	defaultReconnectQueueTimeout = 10 * time.Second
+	defaultDrainPeriod           = 5 * time.Second
+	defaultShutdownTimeout       = 10 * time.Second
+	defaultDialTimeout           = 30 * time.Second
+	defaultCallTimeout           = 60 * time.Second
+	defaultMaxMessageSize        = 4 * 1024 * 1024
+	defaultRefreshInterval       = 10 * time.Second
+	defaultBaseEjectionTime      = 30 * time.Second
+	defaultMaxEjectionPercent    = 50
+	defaultLogLevel              = "info"
+	defaultLogMaxSize            = 100
+	defaultLogMaxBackups         = 3
+	defaultReloadInterval        = 10 * time.Second
+)
+
+const defaultNameResolution = "consul"
+
+// namespacePattern matches the dns labels namespaces are named with.
+var namespacePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?$`)
+
 type ProviderConfig struct {
+	// Version is the version of the configuration format, the latest when omitted.
+	Version int `json:"version"`
+	// ResolverAddress is the consul address used when NameResolution has no configuration.
 	ResolverAddress string `json:"resolver_address"`
+	// ExternalAddress is the host other dapr apps reach the linked actors at. Without it the listeners are
+	// registered without a host and the name resolution picks one, e.g. consul uses the address of its agent.
 	ExternalAddress string `json:"external_address"`
+	// NameResolution selects the discovery backend, like the nameResolution section of a Dapr configuration.
+	NameResolution NameResolutionConfig `json:"name_resolution"`
+	// ReconnectQueueSize is the number of inbound calls allowed to wait while the lattice connection
+	// is reconnecting, 0 fails them immediately.
+	ReconnectQueueSize    int      `json:"reconnect_queue_size"`
+	ReconnectQueueTimeout Duration `json:"reconnect_queue_timeout"`
+	// DrainPeriod is how long a removed link keeps serving after being deregistered.
+	DrainPeriod Duration `json:"drain_period"`
+	// ShutdownTimeout bounds the graceful stop of a listener, remaining calls are closed after it.
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+	// Health controls how the reachability of linked actors is checked.
+	Health HealthConfig `json:"health"`
+	// LoadBalancing controls how calls are spread over the instances of a remote app.
+	LoadBalancing LoadBalancingConfig `json:"load_balancing"`
+	// Remote controls the connections to remote apps.
+	Remote RemoteConfig `json:"remote"`
+	Log    LogConfig    `json:"log"`
+	// AccessLog logs the inbound and outbound calls of linked actors.
+	AccessLog AccessLogConfig `json:"access_log"`
+	// Reload is a source of configuration applied over this one while the provider runs.
+	Reload ReloadConfig `json:"reload"`
+	// Admin serves an http api to inspect the links, the name resolution and the connections of the provider.
+	Admin AdminConfig `json:"admin"`
+	// Invocation controls the metadata of a call given to the actors it reaches.
+	Invocation InvocationConfig `json:"invocation"`
+}
+
+type NameResolutionConfig struct {
+	// Component is one of the registered backends: consul, mdns, kubernetes, static or standalone.
+	Component     string      `json:"component"`
+	Configuration interface{} `json:"configuration"`
+}
+
+type HealthConfig struct {
+	Interval         Duration `json:"interval"`
+	Timeout          Duration `json:"timeout"`
+	FailureThreshold int      `json:"failure_threshold"`
+	SuccessThreshold int      `json:"success_threshold"`
+}
+
+func (h HealthConfig) options() health.Options {
+	return health.Options{
+		Interval:         h.Interval.Duration,
+		Timeout:          h.Timeout.Duration,
+		FailureThreshold: h.FailureThreshold,
+		SuccessThreshold: h.SuccessThreshold,
+	}
+}
+
+type LoadBalancingConfig struct {
+	// Policy is one of round_robin, weighted or locality.
+	Policy string `json:"policy" enum:"round_robin,weighted,locality"`
+	// Locality is the zone preferred by the locality policy.
+	Locality string `json:"locality"`
+	// RefreshInterval is how often the instances of a remote app are resolved again.
+	RefreshInterval Duration      `json:"refresh_interval"`
+	Outlier         OutlierConfig `json:"outlier"`
+	// VersionSplit maps app ids to the weights of their versions, for calls without a dapr-app-version header.
+	VersionSplit map[string]map[string]int `json:"version_split"`
+}
+
+type OutlierConfig struct {
+	ConsecutiveFailures int      `json:"consecutive_failures"`
+	BaseEjectionTime    Duration `json:"base_ejection_time"`
+	MaxEjectionPercent  int      `json:"max_ejection_percent"`
+}
+
+func (c LoadBalancingConfig) options(appID string) loadbalancer.Options {
+	return loadbalancer.Options{
+		Versions: c.VersionSplit[appID],
+		Policy:   c.Policy,
+		Locality: c.Locality,
+		Outlier: loadbalancer.OutlierOptions{
+			ConsecutiveFailures: c.Outlier.ConsecutiveFailures,
+			BaseEjectionTime:    c.Outlier.BaseEjectionTime,
+			MaxEjectionPercent:  c.Outlier.MaxEjectionPercent,
+		},
+	}
+}
+
+type RemoteConfig struct {
+	// DialTimeout bounds the first connection to a remote app.
+	DialTimeout Duration `json:"dial_timeout"`
+	// CallTimeout bounds a call of an actor to a remote app, 0 means no deadline.
+	CallTimeout Duration `json:"call_timeout"`
+	// MaxConnIdle is how long an unused connection to a remote app is kept open.
+	MaxConnIdle Duration `json:"max_conn_idle"`
+	// MinActiveConns is the number of connections per remote app kept open even when idle.
+	MinActiveConns int `json:"min_active_conns"`
+	// MaxMessageSize is the largest request or response in bytes.
+	MaxMessageSize int       `json:"max_message_size"`
+	TLS            TLSConfig `json:"tls"`
+}
+
+// TLSConfig secures the connections to remote apps, they are plaintext unless enabled.
+type TLSConfig struct {
+	Enabled bool `json:"enabled"`
+	// CAFile verifies the remote certificates instead of the system roots.
+	CAFile string `json:"ca_file"`
+	// CertFile and KeyFile are the client certificate for mutual TLS.
+	CertFile           string `json:"cert_file"`
+	KeyFile            string `json:"key_file"`
+	ServerName         string `json:"server_name"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+}
+
+// credentials returns the transport credentials of the connections to remote apps.
+func (c TLSConfig) credentials() (credentials.TransportCredentials, error) {
+	if !c.Enabled {
+		return insecure.NewCredentials(), nil
+	}
+	conf := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", c.CAFile)
+		}
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return credentials.NewTLS(conf), nil
+}
+
+type LogConfig struct {
+	Level  string `json:"level" enum:"debug,info,warn,error,fatal"`
+	Format string `json:"format" enum:"text,json"`
+	// Output is stderr, stdout or the path of a file.
+	Output string `json:"output"`
+	// MaxSize is the size in megabytes after which the file output is rotated, 0 never rotates.
+	MaxSize    int `json:"max_size"`
+	MaxBackups int `json:"max_backups"`
+	// Components sets the level of loggers by name, e.g. {"wasmcloud.dapr.server": "debug"}.
+	Components map[string]string `json:"components"`
+}
+
+func (c LogConfig) options() logging.Options {
+	return logging.Options{
+		Level:      c.Level,
+		Format:     c.Format,
+		Output:     c.Output,
+		MaxSize:    int64(c.MaxSize) * 1024 * 1024,
+		MaxBackups: c.MaxBackups,
+		Components: c.Components,
+	}
+}
+
+func (c LogConfig) apply() error {
+	return logging.Apply(c.options())
+}
+
+// AccessLogConfig configures the entries of the wasmcloud.access logger, written at info level.
+type AccessLogConfig struct {
+	Enabled bool `json:"enabled"`
+	// SampleRate is the fraction of successful calls logged, failed calls are always logged.
+	SampleRate float64 `json:"sample_rate"`
+	// Headers logs the request headers, the values of RedactHeaders and of credential headers are replaced.
+	Headers       bool     `json:"headers"`
+	RedactHeaders []string `json:"redact_headers"`
+	// BodyLimit logs up to BodyLimit bytes of the request and response bodies, 0 logs none.
+	BodyLimit int `json:"body_limit"`
+}
+
+func (c AccessLogConfig) apply() {
+	accesslog.Apply(accesslog.Options{
+		Enabled:       c.Enabled,
+		SampleRate:    c.SampleRate,
+		Headers:       c.Headers,
+		RedactHeaders: c.RedactHeaders,
+		BodyLimit:     c.BodyLimit,
+	})
+}
+
+// ReloadConfig watches a json document with the same fields as the provider configuration,
+// either a file read every Interval or a NATS key value entry.
+type ReloadConfig struct {
+	File     string   `json:"file"`
+	Interval Duration `json:"interval"`
+	Bucket   string   `json:"bucket"`
+	Key      string   `json:"key"`
+}
+
+// AdminConfig enables the admin api, which is disabled by default.
+type AdminConfig struct {
+	// Address is the host:port the admin api listens on, e.g. 127.0.0.1:9090.
+	Address string `json:"address"`
+	// Token is required as a bearer token by every request when set.
+	Token string `json:"token"`
+}
+
+// InvocationConfig controls the caller headers added to the calls of linked actors and the headers
+// describing a call which are added to the requests sent to them.
+type InvocationConfig struct {
+	// Namespace is sent as dapr-caller-namespace with the calls of linked actors when set.
+	Namespace string                  `json:"namespace"`
+	Headers   InvocationHeadersConfig `json:"headers"`
+}
+
+// InvocationHeadersConfig selects the headers added to the requests sent to linked actors.
+type InvocationHeadersConfig struct {
+	// CallerAppID adds dapr-caller-app-id, the app id of the caller.
+	CallerAppID bool `json:"caller_app_id"`
+	// CallerNamespace adds dapr-caller-namespace, the namespace of the caller.
+	CallerNamespace bool `json:"caller_namespace"`
+	// Deadline adds dapr-deadline, the deadline of the call in RFC 3339 format.
+	Deadline bool `json:"deadline"`
+	// TraceID adds dapr-trace-id, the trace id of the traceparent of the call.
+	TraceID bool `json:"trace_id"`
 }
+
+func (c InvocationHeadersConfig) apply() {
+	server.ApplyInvocationHeaders(server.InvocationHeaders{
+		CallerAppID:     c.CallerAppID,
+		CallerNamespace: c.CallerNamespace,
+		Deadline:        c.Deadline,
+		TraceID:         c.TraceID,
+	})
+}
+
+func defaultConfig() ProviderConfig {
+	return ProviderConfig{
+		Version:               configVersion,
+		ReconnectQueueTimeout: Duration{Duration: defaultReconnectQueueTimeout},
+		DrainPeriod:           Duration{Duration: defaultDrainPeriod},
+		ShutdownTimeout:       Duration{Duration: defaultShutdownTimeout},
+		NameResolution:        NameResolutionConfig{Component: defaultNameResolution},
+		Health: HealthConfig{
+			Interval:         Duration{Duration: health.DefaultInterval},
+			Timeout:          Duration{Duration: health.DefaultTimeout},
+			FailureThreshold: health.DefaultFailureThreshold,
+			SuccessThreshold: health.DefaultSuccessThreshold,
+		},
+		LoadBalancing: LoadBalancingConfig{
+			Policy:          loadbalancer.PolicyRoundRobin,
+			RefreshInterval: Duration{Duration: defaultRefreshInterval},
+			Outlier: OutlierConfig{
+				BaseEjectionTime:   Duration{Duration: defaultBaseEjectionTime},
+				MaxEjectionPercent: defaultMaxEjectionPercent,
+			},
+		},
+		Remote: RemoteConfig{
+			DialTimeout:    Duration{Duration: defaultDialTimeout},
+			CallTimeout:    Duration{Duration: defaultCallTimeout},
+			MaxConnIdle:    Duration{Duration: defaultMaxConnIdle},
+			MaxMessageSize: defaultMaxMessageSize,
+		},
+		Log: LogConfig{
+			Level:      defaultLogLevel,
+			Format:     logging.FormatText,
+			Output:     logging.OutputStderr,
+			MaxSize:    defaultLogMaxSize,
+			MaxBackups: defaultLogMaxBackups,
+		},
+		AccessLog: AccessLogConfig{SampleRate: 1},
+		Reload:    ReloadConfig{Interval: Duration{Duration: defaultReloadInterval}},
+		Invocation: InvocationConfig{
+			Headers: InvocationHeadersConfig{CallerAppID: true, CallerNamespace: true, Deadline: true, TraceID: true},
+		},
+	}
+}
+
+// loadConfig parses the provider configuration documents in order over the defaults, then applies the
+// environment overrides. Unknown fields are rejected, the result still has to be validated.
+func loadConfig(raws ...string) (ProviderConfig, error) {
+	c := defaultConfig()
+	for _, raw := range raws {
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+		if err := decodeConfig(raw, &c); err != nil {
+			return c, err
+		}
+	}
+	err := applyEnv(&c, os.LookupEnv)
+	return c, err
+}
+
+func decodeConfig(raw string, c *ProviderConfig) error {
+	dec := json.NewDecoder(strings.NewReader(raw))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(c)
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case err == nil:
+	case errors.As(err, &syntaxErr):
+		return fmt.Errorf("config: invalid json at offset %d: %s", syntaxErr.Offset, syntaxErr)
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return fmt.Errorf("config: %s must be %s, got %s", typeErr.Field, jsonKind(typeErr.Type), typeErr.Value)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return fmt.Errorf("config: %s, see config.schema.json for the supported fields",
+			strings.TrimPrefix(err.Error(), "json: "))
+	default:
+		return fmt.Errorf("config: %w", err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("config: unexpected data after the configuration object")
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once.
+func (c ProviderConfig) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+	nonNegative := func(name string, d Duration) {
+		check(d.Duration >= 0, "%s must not be negative, got %s", name, d)
+	}
+
+	check(c.Version >= 0 && c.Version <= configVersion, "version %d is not supported, the latest is %d", c.Version, configVersion)
+	if c.ResolverAddress != "" {
+		u, err := url.Parse(c.ResolverAddress)
+		check(err == nil && u.Host != "", "resolver_address must be a url like http://127.0.0.1:8500, got %q", c.ResolverAddress)
+	}
+	if c.ExternalAddress != "" {
+		_, _, err := net.SplitHostPort(c.ExternalAddress)
+		check(err != nil, "external_address must be a host without a port, got %q", c.ExternalAddress)
+	}
+	names := discovery.Names()
+	check(contains(names, c.NameResolution.Component), "name_resolution.component %q is not one of %s",
+		c.NameResolution.Component, strings.Join(names, ", "))
+
+	check(c.ReconnectQueueSize >= 0, "reconnect_queue_size must not be negative, got %d", c.ReconnectQueueSize)
+	nonNegative("reconnect_queue_timeout", c.ReconnectQueueTimeout)
+	nonNegative("drain_period", c.DrainPeriod)
+	nonNegative("shutdown_timeout", c.ShutdownTimeout)
+
+	nonNegative("health.interval", c.Health.Interval)
+	nonNegative("health.timeout", c.Health.Timeout)
+	check(c.Health.FailureThreshold >= 0, "health.failure_threshold must not be negative, got %d", c.Health.FailureThreshold)
+	check(c.Health.SuccessThreshold >= 0, "health.success_threshold must not be negative, got %d", c.Health.SuccessThreshold)
+
+	lb := c.LoadBalancing
+	policies := []string{loadbalancer.PolicyRoundRobin, loadbalancer.PolicyWeighted, loadbalancer.PolicyLocality}
+	check(lb.Policy == "" || contains(policies, lb.Policy), "load_balancing.policy %q is not one of %s",
+		lb.Policy, strings.Join(policies, ", "))
+	check(lb.Policy != loadbalancer.PolicyLocality || lb.Locality != "", "load_balancing.locality is required by the locality policy")
+	nonNegative("load_balancing.refresh_interval", lb.RefreshInterval)
+	check(lb.Outlier.ConsecutiveFailures >= 0, "load_balancing.outlier.consecutive_failures must not be negative, got %d", lb.Outlier.ConsecutiveFailures)
+	nonNegative("load_balancing.outlier.base_ejection_time", lb.Outlier.BaseEjectionTime)
+	check(lb.Outlier.MaxEjectionPercent >= 0 && lb.Outlier.MaxEjectionPercent <= 100,
+		"load_balancing.outlier.max_ejection_percent must be between 0 and 100, got %d", lb.Outlier.MaxEjectionPercent)
+	for _, appID := range sortedKeys(lb.VersionSplit) {
+		total := 0
+		for version, weight := range lb.VersionSplit[appID] {
+			check(weight >= 0, "load_balancing.version_split.%s.%s must not be negative, got %d", appID, version, weight)
+			total += weight
+		}
+		check(total > 0, "load_balancing.version_split.%s needs a positive weight", appID)
+	}
+	// kubernetes resolves the single address of a service, which has no version to split by
+	check(len(lb.VersionSplit) == 0 || c.NameResolution.Component != "kubernetes",
+		"load_balancing.version_split is not supported by the kubernetes name resolution")
+
+	nonNegative("remote.dial_timeout", c.Remote.DialTimeout)
+	nonNegative("remote.call_timeout", c.Remote.CallTimeout)
+	nonNegative("remote.max_conn_idle", c.Remote.MaxConnIdle)
+	check(c.Remote.MinActiveConns >= 0, "remote.min_active_conns must not be negative, got %d", c.Remote.MinActiveConns)
+	check(c.Remote.MaxMessageSize >= 0, "remote.max_message_size must not be negative, got %d", c.Remote.MaxMessageSize)
+	t := c.Remote.TLS
+	check((t.CertFile == "") == (t.KeyFile == ""), "remote.tls.cert_file and remote.tls.key_file must be set together")
+	if t.Enabled {
+		if _, err := t.credentials(); err != nil {
+			check(false, "remote.tls: %s", err)
+		}
+	}
+
+	check(c.Reload.File == "" || c.Reload.Bucket == "", "reload.file and reload.bucket can't be used together")
+	check((c.Reload.Bucket == "") == (c.Reload.Key == ""), "reload.bucket and reload.key must be set together")
+	check(c.Reload.Interval.Duration > 0, "reload.interval must be positive, got %s", c.Reload.Interval)
+
+	if c.Admin.Address != "" {
+		_, _, err := net.SplitHostPort(c.Admin.Address)
+		check(err == nil, "admin.address must be host:port, got %q", c.Admin.Address)
+	}
+
+	levels := []string{"debug", "info", "warn", "error", "fatal"}
+	check(contains(levels, c.Log.Level), "log.level %q is not one of %s", c.Log.Level, strings.Join(levels, ", "))
+	for _, name := range sortedStringKeys(c.Log.Components) {
+		level := c.Log.Components[name]
+		check(contains(levels, level), "log.components.%s %q is not one of %s", name, level, strings.Join(levels, ", "))
+	}
+	check(c.Log.Format == logging.FormatText || c.Log.Format == logging.FormatJSON, "log.format %q is not one of text, json", c.Log.Format)
+	check(c.Log.MaxSize >= 0, "log.max_size must not be negative, got %d", c.Log.MaxSize)
+	check(c.Log.MaxBackups >= 0, "log.max_backups must not be negative, got %d", c.Log.MaxBackups)
+
+	check(c.AccessLog.SampleRate >= 0 && c.AccessLog.SampleRate <= 1, "access_log.sample_rate must be between 0 and 1, got %g", c.AccessLog.SampleRate)
+	check(c.AccessLog.BodyLimit >= 0, "access_log.body_limit must not be negative, got %d", c.AccessLog.BodyLimit)
+
+	ns := c.Invocation.Namespace
+	check(ns == "" || namespacePattern.MatchString(ns), "invocation.namespace %q is not a lowercase dns label", ns)
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// applyEnv overrides the scalar fields of c with the environment variables named after their json path,
+// e.g. HTTP_PROVIDER_LOAD_BALANCING_POLICY.
+func applyEnv(c *ProviderConfig, lookup func(string) (string, bool)) error {
+	return applyEnvValue(reflect.ValueOf(c).Elem(), envPrefix, lookup)
+}
+
+func applyEnvValue(v reflect.Value, prefix string, lookup func(string) (string, bool)) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := jsonName(t.Field(i))
+		if name == "" {
+			continue
+		}
+		key := prefix + strings.ToUpper(name)
+		field := v.Field(i)
+		if field.Type() == durationType {
+			if value, ok := lookup(key); ok {
+				d, err := duration.Parse(value)
+				if err != nil {
+					return fmt.Errorf("env %s: %w", key, err)
+				}
+				field.Set(reflect.ValueOf(Duration{Duration: d}))
+			}
+			continue
+		}
+		switch field.Kind() {
+		case reflect.Struct:
+			if err := applyEnvValue(field, key+"_", lookup); err != nil {
+				return err
+			}
+		case reflect.String:
+			if value, ok := lookup(key); ok {
+				field.SetString(value)
+			}
+		case reflect.Int:
+			if value, ok := lookup(key); ok {
+				n, err := strconv.Atoi(value)
+				if err != nil {
+					return fmt.Errorf("env %s must be an integer, got %q", key, value)
+				}
+				field.SetInt(int64(n))
+			}
+		case reflect.Float64:
+			if value, ok := lookup(key); ok {
+				f, err := strconv.ParseFloat(value, 64)
+				if err != nil {
+					return fmt.Errorf("env %s must be a number, got %q", key, value)
+				}
+				field.SetFloat(f)
+			}
+		case reflect.Slice:
+			if value, ok := lookup(key); ok && field.Type().Elem().Kind() == reflect.String {
+				// a comma separated list
+				var list []string
+				for _, item := range strings.Split(value, ",") {
+					if item = strings.TrimSpace(item); item != "" {
+						list = append(list, item)
+					}
+				}
+				field.Set(reflect.ValueOf(list))
+			}
+		case reflect.Bool:
+			if value, ok := lookup(key); ok {
+				b, err := strconv.ParseBool(value)
+				if err != nil {
+					return fmt.Errorf("env %s must be true or false, got %q", key, value)
+				}
+				field.SetBool(b)
+			}
+		}
+	}
+	return nil
+}
+
+// configSchema describes ProviderConfig as a JSON schema, with the defaults of defaultConfig.
+func configSchema() map[string]interface{} {
+	s := schemaOf(reflect.TypeOf(ProviderConfig{}), reflect.ValueOf(defaultConfig()))
+	s["$schema"] = "http://json-schema.org/draft-07/schema#"
+	s["title"] = "wasmcloud dapr http provider configuration"
+	return s
+}
+
+func schemaOf(t reflect.Type, def reflect.Value) map[string]interface{} {
+	if t == durationType {
+		return map[string]interface{}{
+			"type":        []string{"string", "integer"},
+			"description": "a duration like \"5s\" or a number of nanoseconds",
+		}
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		props := map[string]interface{}{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := jsonName(f)
+			if name == "" {
+				continue
+			}
+			p := schemaOf(f.Type, def.Field(i))
+			if enum := f.Tag.Get("enum"); enum != "" {
+				p["enum"] = strings.Split(enum, ",")
+			}
+			isValue := f.Type.Kind() != reflect.Struct || f.Type == durationType
+			if d := def.Field(i); isValue && !d.IsZero() {
+				p["default"] = d.Interface()
+			}
+			props[name] = p
+		}
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           props,
+			"additionalProperties": false,
+		}
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": schemaOf(t.Elem(), reflect.Zero(t.Elem())),
+		}
+	case reflect.Slice:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": schemaOf(t.Elem(), reflect.Zero(t.Elem())),
+		}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Int:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	}
+	// interface{}: passed as is, e.g. to the name resolution backend
+	return map[string]interface{}{}
+}
+
+// marshalSchema renders configSchema the way config.schema.json is written.
+func marshalSchema() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(configSchema())
+	return buf.Bytes(), err
+}
+
+var durationType = reflect.TypeOf(Duration{})
+
+func jsonName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "-" || !f.IsExported() {
+		return ""
+	}
+	return name
+}
+
+func jsonKind(t reflect.Type) string {
+	if t == durationType {
+		return "a duration like \"5s\""
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		return "an integer"
+	case reflect.Float64:
+		return "a number"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Struct, reflect.Map:
+		return "an object"
+	case reflect.Slice:
+		return "an array"
+	}
+	return t.String()
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedStringKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedKeys(m map[string]map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Duration is a time.Duration written as a string like "5s" in json.
+type Duration = duration.Duration
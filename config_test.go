@@ -7,10 +7,20 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
+	"os"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+//go:generate go test -run TestConfigSchema -update
+
+var update = flag.Bool("update", false, "rewrite config.schema.json")
+
 func TestConfig(t *testing.T) {
 	c := ProviderConfig{
 		ResolverAddress: "http://127.0.0.1:8500",
@@ -20,3 +30,118 @@ func TestConfig(t *testing.T) {
 
 	fmt.Println(string(byt))
 }
+
+func TestLoadConfig(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		c, err := loadConfig("")
+		require.NoError(t, err)
+		assert.Equal(t, configVersion, c.Version)
+		assert.Equal(t, defaultDialTimeout, c.Remote.DialTimeout.Duration)
+		assert.Equal(t, defaultMaxMessageSize, c.Remote.MaxMessageSize)
+		assert.Equal(t, defaultLogLevel, c.Log.Level)
+		assert.NoError(t, c.Validate())
+		assert.Empty(t, c.ExternalAddress)
+	})
+
+	t.Run("example", func(t *testing.T) {
+		raw, err := os.ReadFile("tests/config/provider.json")
+		require.NoError(t, err)
+		c, err := loadConfig(string(raw))
+		require.NoError(t, err)
+		assert.NoError(t, c.Validate())
+		assert.Equal(t, "127.0.0.1", c.ExternalAddress)
+	})
+
+	t.Run("overrides", func(t *testing.T) {
+		c, err := loadConfig(`{"external_address": "10.0.0.1", "remote": {"dial_timeout": "5s", "tls": {"enabled": true}}}`)
+		require.NoError(t, err)
+		assert.Equal(t, 5*time.Second, c.Remote.DialTimeout.Duration)
+		assert.True(t, c.Remote.TLS.Enabled)
+		assert.Equal(t, defaultMaxConnIdle, c.Remote.MaxConnIdle.Duration)
+		assert.NoError(t, c.Validate())
+	})
+
+	errs := map[string]string{
+		`{"external_adress": "127.0.0.1"}`:           `config: unknown field "external_adress", see config.schema.json for the supported fields`,
+		`{"remote": {"max_message_size": "4MB"}}`:    `config: remote.max_message_size must be an integer, got string`,
+		`{"health": {"interval": "often"}}`:          `config: invalid duration "often", use a value like "5s" or "1m30s"`,
+		`{"external_address": "127.0.0.1",}`:         `config: invalid json at offset 34: invalid character '}' looking for beginning of object key string`,
+		`{"external_address": "127.0.0.1"} {"x": 1}`: `config: unexpected data after the configuration object`,
+	}
+	for raw, msg := range errs {
+		_, err := loadConfig(raw)
+		assert.EqualError(t, err, msg, raw)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := defaultConfig()
+	c.Version = 2
+	c.ExternalAddress = "127.0.0.1:8888"
+	c.NameResolution.Component = "etcd"
+	c.DrainPeriod = Duration{Duration: -time.Second}
+	c.LoadBalancing.Policy = "locality"
+	c.LoadBalancing.VersionSplit = map[string]map[string]int{"a": {"v1": 0}}
+	c.Remote.TLS.CertFile = "client.pem"
+	c.Log.Level = "verbose"
+	c.AccessLog.SampleRate = 1.5
+	c.Invocation.Namespace = "Shop"
+
+	assert.EqualError(t, c.Validate(), `invalid configuration:
+  version 2 is not supported, the latest is 1
+  external_address must be a host without a port, got "127.0.0.1:8888"
+  name_resolution.component "etcd" is not one of consul, kubernetes, mdns, standalone, static
+  drain_period must not be negative, got -1s
+  load_balancing.locality is required by the locality policy
+  load_balancing.version_split.a needs a positive weight
+  remote.tls.cert_file and remote.tls.key_file must be set together
+  log.level "verbose" is not one of debug, info, warn, error, fatal
+  access_log.sample_rate must be between 0 and 1, got 1.5
+  invocation.namespace "Shop" is not a lowercase dns label`)
+
+	c = defaultConfig()
+	c.NameResolution.Component = "kubernetes"
+	c.LoadBalancing.VersionSplit = map[string]map[string]int{"a": {"v1": 90, "v2": 10}}
+	assert.EqualError(t, c.Validate(), `invalid configuration:
+  load_balancing.version_split is not supported by the kubernetes name resolution`)
+}
+
+func TestApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"HTTP_PROVIDER_EXTERNAL_ADDRESS":                            "10.0.0.2",
+		"HTTP_PROVIDER_NAME_RESOLUTION_COMPONENT":                   "mdns",
+		"HTTP_PROVIDER_REMOTE_DIAL_TIMEOUT":                         "2s",
+		"HTTP_PROVIDER_REMOTE_TLS_ENABLED":                          "true",
+		"HTTP_PROVIDER_LOAD_BALANCING_OUTLIER_MAX_EJECTION_PERCENT": "20",
+		"HTTP_PROVIDER_ACCESS_LOG_SAMPLE_RATE":                      "0.25",
+		"HTTP_PROVIDER_ACCESS_LOG_REDACT_HEADERS":                   "x-token, x-secret",
+	}
+	lookup := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+	c := defaultConfig()
+	require.NoError(t, applyEnv(&c, lookup))
+	assert.Equal(t, "10.0.0.2", c.ExternalAddress)
+	assert.Equal(t, "mdns", c.NameResolution.Component)
+	assert.Equal(t, 2*time.Second, c.Remote.DialTimeout.Duration)
+	assert.True(t, c.Remote.TLS.Enabled)
+	assert.Equal(t, 20, c.LoadBalancing.Outlier.MaxEjectionPercent)
+	assert.Equal(t, 0.25, c.AccessLog.SampleRate)
+	assert.Equal(t, []string{"x-token", "x-secret"}, c.AccessLog.RedactHeaders)
+
+	env["HTTP_PROVIDER_RECONNECT_QUEUE_SIZE"] = "many"
+	assert.EqualError(t, applyEnv(&c, lookup), `env HTTP_PROVIDER_RECONNECT_QUEUE_SIZE must be an integer, got "many"`)
+}
+
+// TestConfigSchema keeps config.schema.json in sync with ProviderConfig, run with -update to regenerate it.
+func TestConfigSchema(t *testing.T) {
+	schema, err := marshalSchema()
+	require.NoError(t, err)
+	if *update {
+		require.NoError(t, os.WriteFile("config.schema.json", schema, 0o644))
+	}
+	written, err := os.ReadFile("config.schema.json")
+	require.NoError(t, err)
+	assert.Equal(t, string(schema), string(written), "config.schema.json is outdated, run go generate")
+}
@@ -0,0 +1,98 @@
+package consul
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/taction/http-provider-go/duration"
+)
+
+const (
+	defaultAntiEntropyInterval = 30 * time.Second
+	maxAntiEntropyBackoff      = 5 * time.Minute
+	ownerMetaKey               = "wasmcloud_owner"
+)
+
+// AntiEntropyConfig controls the loop keeping the registrations of the agent in sync with the linked apps.
+type AntiEntropyConfig struct {
+	Disabled bool
+	// Interval is written like "30s".
+	Interval duration.Duration
+	// Owner tags the registrations of this provider, registrations of the same owner which are not
+	// linked anymore are removed. Defaults to hostname-pid-n, n numbering the resolvers of the process;
+	// a configured owner must be unique per provider sharing an agent.
+	Owner string
+}
+
+// resolvers numbers the resolvers created by the process, each one owns its registrations.
+var resolvers atomic.Int32
+
+func defaultOwner() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), resolvers.Add(1))
+}
+
+// antiEntropy reconciles the agent every interval until the resolver is closed, backing off while
+// the agent is unreachable.
+func (r *Resolver) antiEntropy(interval time.Duration) {
+	wait := interval
+	for {
+		timer := time.NewTimer(wait)
+		select {
+		case <-r.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if err := r.reconcile(); err != nil {
+			wait *= 2
+			if wait > maxAntiEntropyBackoff {
+				wait = maxAntiEntropyBackoff
+			}
+			r.logger.Warnf("consul anti-entropy failed, retrying in %s: %s", wait, err)
+			continue
+		}
+		wait = interval
+	}
+}
+
+// reconcile registers the linked apps missing from the agent, e.g. after it lost its state, and
+// deregisters the services of the owner which are not linked anymore.
+func (r *Resolver) reconcile() error {
+	services, err := r.client.Agent().Services()
+	if err != nil {
+		return fmt.Errorf("failed to list consul agent services: %w", err)
+	}
+
+	r.l.Lock()
+	defer r.l.Unlock()
+	var failed error
+	for id, a := range r.apps {
+		if _, ok := services[id]; ok {
+			continue
+		}
+		registration, err := r.registration(a)
+		if err == nil {
+			err = r.client.Agent().ServiceRegister(registration)
+		}
+		if err != nil {
+			failed = fmt.Errorf("failed to register consul service %s: %w", id, err)
+			continue
+		}
+		r.logger.Infof("re-registered consul service %s missing from the agent", id)
+	}
+	for id, s := range services {
+		if _, ok := r.apps[id]; ok || s.Meta[ownerMetaKey] != r.owner {
+			continue
+		}
+		if err := r.client.Agent().ServiceDeregister(id); err != nil {
+			failed = fmt.Errorf("failed to deregister orphan consul service %s: %w", id, err)
+			continue
+		}
+		r.logger.Infof("deregistered orphan consul service %s", id)
+	}
+	return failed
+}
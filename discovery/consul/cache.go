@@ -0,0 +1,264 @@
+package consul
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dapr/kit/logger"
+	consul "github.com/hashicorp/consul/api"
+
+	"github.com/taction/http-provider-go/duration"
+)
+
+const (
+	defaultCacheTTL      = 30 * time.Second
+	defaultCacheWaitTime = 5 * time.Minute
+	defaultCacheIdle     = 10 * time.Minute
+	// minWatchInterval rate limits blocking queries which return immediately.
+	minWatchInterval = time.Second
+	maxWatchBackoff  = 30 * time.Second
+)
+
+// CacheConfig enables an in-memory cache of the healthy endpoints of every resolved app id,
+// kept fresh with consul blocking queries. Durations are written like "30s".
+type CacheConfig struct {
+	Enabled bool
+	// TTL is how old an entry may get while its watch is failing before it is queried again in the background,
+	// the entries of working watches are served whatever their age.
+	TTL duration.Duration
+	// WaitTime is the maximum duration of a blocking query.
+	WaitTime duration.Duration
+	// IdleTimeout stops watching app ids which have not been resolved for that long.
+	IdleTimeout duration.Duration
+}
+
+// CacheStats counts the activity of the endpoint cache.
+type CacheStats struct {
+	Entries   int    `json:"entries"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Refreshes uint64 `json:"refreshes"`
+	Stale     uint64 `json:"stale"`
+	Errors    uint64 `json:"errors"`
+}
+
+type cacheEntry struct {
+	l        sync.RWMutex
+	services []*consul.ServiceEntry
+	index    uint64
+	updated  time.Time
+	// failed is when the queries of the entry started failing, zero while they succeed.
+	failed   time.Time
+	lastUsed atomic.Int64
+	// refreshing is set while a stale entry is queried in the background.
+	refreshing atomic.Bool
+}
+
+type endpointCache struct {
+	config  CacheConfig
+	health  func() healthInterface
+	options func() *consul.QueryOptions
+	logger  logger.Logger
+
+	l       sync.Mutex
+	entries map[string]*cacheEntry
+	stop    chan struct{}
+
+	hits, misses, refreshes, stale, errors atomic.Uint64
+}
+
+func newEndpointCache(config CacheConfig, health func() healthInterface, options func() *consul.QueryOptions, logger logger.Logger) *endpointCache {
+	if config.TTL.Duration <= 0 {
+		config.TTL.Duration = defaultCacheTTL
+	}
+	if config.WaitTime.Duration <= 0 {
+		config.WaitTime.Duration = defaultCacheWaitTime
+	}
+	if config.IdleTimeout.Duration <= 0 {
+		config.IdleTimeout.Duration = defaultCacheIdle
+	}
+	return &endpointCache{
+		config:  config,
+		health:  health,
+		options: options,
+		logger:  logger,
+		entries: make(map[string]*cacheEntry),
+		stop:    make(chan struct{}),
+	}
+}
+
+// services returns the healthy services of appID, from the cache when possible. Entries whose watch is failing
+// are served until they are older than the TTL, then they are served right away while they are queried again
+// in the background.
+func (c *endpointCache) services(appID string) ([]*consul.ServiceEntry, error) {
+	c.l.Lock()
+	entry, ok := c.entries[appID]
+	if !ok {
+		entry = &cacheEntry{}
+		c.entries[appID] = entry
+	}
+	c.l.Unlock()
+	entry.lastUsed.Store(time.Now().UnixNano())
+
+	if ok {
+		entry.l.RLock()
+		services, updated, failed := entry.services, entry.updated, entry.failed
+		entry.l.RUnlock()
+		if !updated.IsZero() && (failed.IsZero() || time.Since(updated) < c.config.TTL.Duration) {
+			c.hits.Add(1)
+			return services, nil
+		}
+		if !updated.IsZero() {
+			// the watch is failing
+			c.stale.Add(1)
+			c.refreshStale(appID, entry, updated)
+			return services, nil
+		}
+		// the first query of the entry failed or is still running
+		if err := c.refresh(appID, entry, 0); err != nil {
+			return nil, err
+		}
+		c.hits.Add(1)
+		entry.l.RLock()
+		defer entry.l.RUnlock()
+		return entry.services, nil
+	}
+
+	c.misses.Add(1)
+	if err := c.refresh(appID, entry, 0); err != nil {
+		c.l.Lock()
+		delete(c.entries, appID)
+		c.l.Unlock()
+		return nil, err
+	}
+	go c.watch(appID, entry)
+	entry.l.RLock()
+	defer entry.l.RUnlock()
+	return entry.services, nil
+}
+
+// refreshStale queries consul for a stale entry in the background, unless a query is running.
+func (c *endpointCache) refreshStale(appID string, entry *cacheEntry, updated time.Time) {
+	if !entry.refreshing.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer entry.refreshing.Store(false)
+		if err := c.refresh(appID, entry, 0); err != nil {
+			c.logger.Warnf("serving stale endpoints of %s updated at %s: %s", appID, updated, err)
+		}
+	}()
+}
+
+// refresh queries consul for appID, blocking until the index changes when index is not 0.
+func (c *endpointCache) refresh(appID string, entry *cacheEntry, index uint64) error {
+	q := &consul.QueryOptions{}
+	if o := c.options(); o != nil {
+		*q = *o
+	}
+	if index > 0 {
+		q.WaitIndex = index
+		q.WaitTime = c.config.WaitTime.Duration
+		// blocking queries can't be served by the agent cache
+		q.UseCache = false
+	}
+	services, meta, err := c.health().Service(appID, "", true, q)
+	if err != nil {
+		c.errors.Add(1)
+		entry.l.Lock()
+		if entry.failed.IsZero() {
+			entry.failed = time.Now()
+		}
+		entry.l.Unlock()
+		return fmt.Errorf("failed to query healthy consul services: %w", err)
+	}
+	c.refreshes.Add(1)
+
+	entry.l.Lock()
+	entry.services = services
+	entry.updated = time.Now()
+	entry.failed = time.Time{}
+	if meta != nil {
+		entry.index = meta.LastIndex
+	}
+	entry.l.Unlock()
+	return nil
+}
+
+// watch keeps the entry of appID fresh until it is idle or the cache is closed.
+func (c *endpointCache) watch(appID string, entry *cacheEntry) {
+	backoff := minWatchInterval
+	for {
+		c.l.Lock()
+		current := c.entries[appID] == entry
+		idle := time.Since(time.Unix(0, entry.lastUsed.Load())) > c.config.IdleTimeout.Duration
+		if current && idle {
+			delete(c.entries, appID)
+			c.logger.Debugf("stop watching idle app %s", appID)
+		}
+		c.l.Unlock()
+		if !current || idle {
+			return
+		}
+
+		entry.l.RLock()
+		index := entry.index
+		entry.l.RUnlock()
+		start := time.Now()
+		err := c.refresh(appID, entry, index)
+		wait := minWatchInterval - time.Since(start)
+		if err != nil {
+			c.logger.Warnf("watch of %s failed, retrying in %s: %s", appID, backoff, err)
+			wait = backoff
+			backoff *= 2
+			if backoff > maxWatchBackoff {
+				backoff = maxWatchBackoff
+			}
+		} else {
+			backoff = minWatchInterval
+		}
+
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-c.stop:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		} else {
+			select {
+			case <-c.stop:
+				return
+			default:
+			}
+		}
+	}
+}
+
+// flush drops every entry, their watches stop after the pending query.
+func (c *endpointCache) flush() {
+	c.l.Lock()
+	c.entries = make(map[string]*cacheEntry)
+	c.l.Unlock()
+}
+
+func (c *endpointCache) close() {
+	close(c.stop)
+}
+
+func (c *endpointCache) stats() CacheStats {
+	c.l.Lock()
+	entries := len(c.entries)
+	c.l.Unlock()
+	return CacheStats{
+		Entries:   entries,
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Refreshes: c.refreshes.Load(),
+		Stale:     c.stale.Load(),
+		Errors:    c.errors.Load(),
+	}
+}
@@ -0,0 +1,178 @@
+package consul
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dapr/kit/logger"
+	consul "github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/taction/http-provider-go/duration"
+)
+
+// watchHealth answers blocking queries like consul, it blocks until the index moves past WaitIndex.
+type watchHealth struct {
+	l        sync.Mutex
+	changed  chan struct{}
+	index    uint64
+	services []*consul.ServiceEntry
+	err      error
+	queries  int
+}
+
+func newWatchHealth(services ...*consul.ServiceEntry) *watchHealth {
+	return &watchHealth{changed: make(chan struct{}), index: 1, services: services}
+}
+
+func (h *watchHealth) Service(service, tag string, passingOnly bool, q *consul.QueryOptions) ([]*consul.ServiceEntry, *consul.QueryMeta, error) {
+	h.l.Lock()
+	h.queries++
+	if q.WaitIndex > 0 && q.WaitIndex == h.index && h.err == nil {
+		changed := h.changed
+		h.l.Unlock()
+		select {
+		case <-changed:
+		case <-time.After(q.WaitTime):
+		}
+		h.l.Lock()
+	}
+	defer h.l.Unlock()
+	if h.err != nil {
+		return nil, nil, h.err
+	}
+	return h.services, &consul.QueryMeta{LastIndex: h.index}, nil
+}
+
+func (h *watchHealth) set(err error, services ...*consul.ServiceEntry) {
+	h.l.Lock()
+	h.err, h.services = err, services
+	h.index++
+	close(h.changed)
+	h.changed = make(chan struct{})
+	h.l.Unlock()
+}
+
+func (h *watchHealth) count() int {
+	h.l.Lock()
+	defer h.l.Unlock()
+	return h.queries
+}
+
+func serviceEntry(address string) *consul.ServiceEntry {
+	return &consul.ServiceEntry{Service: &consul.AgentService{Address: address, Meta: map[string]string{daprMeta: "50002"}}}
+}
+
+func newTestCache(h *watchHealth, ttl time.Duration) *endpointCache {
+	c := newEndpointCache(CacheConfig{Enabled: true, TTL: duration.Duration{Duration: ttl}, WaitTime: duration.Duration{Duration: time.Second}},
+		func() healthInterface { return h },
+		func() *consul.QueryOptions { return &consul.QueryOptions{UseCache: true} },
+		logger.NewLogger("test"))
+	return c
+}
+
+func TestEndpointCache(t *testing.T) {
+	t.Run("hits after the first query", func(t *testing.T) {
+		h := newWatchHealth(serviceEntry("10.0.0.1"))
+		c := newTestCache(h, time.Minute)
+		defer c.close()
+
+		for i := 0; i < 3; i++ {
+			services, err := c.services("app")
+			require.NoError(t, err)
+			assert.Equal(t, "10.0.0.1", services[0].Service.Address)
+		}
+		stats := c.stats()
+		assert.Equal(t, uint64(1), stats.Misses)
+		assert.Equal(t, uint64(2), stats.Hits)
+		assert.Equal(t, 1, stats.Entries)
+	})
+
+	t.Run("watch picks up changes", func(t *testing.T) {
+		h := newWatchHealth(serviceEntry("10.0.0.1"))
+		c := newTestCache(h, time.Minute)
+		defer c.close()
+
+		_, err := c.services("app")
+		require.NoError(t, err)
+		assert.Eventually(t, func() bool { return h.count() > 1 }, time.Second, 10*time.Millisecond)
+
+		h.set(nil, serviceEntry("10.0.0.2"))
+		assert.Eventually(t, func() bool {
+			services, err := c.services("app")
+			return err == nil && services[0].Service.Address == "10.0.0.2"
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("entries of working watches don't get stale", func(t *testing.T) {
+		h := newWatchHealth(serviceEntry("10.0.0.1"))
+		c := newTestCache(h, 10*time.Millisecond)
+		defer c.close()
+
+		_, err := c.services("app")
+		require.NoError(t, err)
+		// the blocking query of the watch outlives the ttl
+		time.Sleep(20 * time.Millisecond)
+		queries := h.count()
+		_, err = c.services("app")
+		require.NoError(t, err)
+		stats := c.stats()
+		assert.Equal(t, uint64(0), stats.Stale)
+		assert.Equal(t, uint64(1), stats.Hits)
+		assert.Equal(t, queries, h.count())
+	})
+
+	t.Run("serves stale endpoints when consul is unreachable", func(t *testing.T) {
+		h := newWatchHealth(serviceEntry("10.0.0.1"))
+		c := newTestCache(h, 10*time.Millisecond)
+		defer c.close()
+
+		_, err := c.services("app")
+		require.NoError(t, err)
+		h.set(errors.New("unreachable"))
+		time.Sleep(20 * time.Millisecond)
+
+		queries := h.count()
+		services, err := c.services("app")
+		require.NoError(t, err)
+		assert.Equal(t, "10.0.0.1", services[0].Service.Address)
+		assert.Equal(t, uint64(1), c.stats().Stale)
+		// the stale entry is queried again in the background
+		assert.Eventually(t, func() bool { return h.count() > queries }, time.Second, time.Millisecond)
+
+		h.set(nil, serviceEntry("10.0.0.2"))
+		assert.Eventually(t, func() bool {
+			services, err := c.services("app")
+			return err == nil && services[0].Service.Address == "10.0.0.2"
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("fails without data", func(t *testing.T) {
+		h := newWatchHealth()
+		h.set(errors.New("unreachable"))
+		c := newTestCache(h, time.Minute)
+		defer c.close()
+
+		_, err := c.services("app")
+		assert.Error(t, err)
+		assert.Equal(t, 0, c.stats().Entries)
+		assert.Equal(t, uint64(1), c.stats().Errors)
+	})
+
+	t.Run("flush drops entries", func(t *testing.T) {
+		h := newWatchHealth(serviceEntry("10.0.0.1"))
+		c := newTestCache(h, time.Minute)
+		defer c.close()
+
+		_, err := c.services("app")
+		require.NoError(t, err)
+		c.flush()
+		assert.Equal(t, 0, c.stats().Entries)
+		_, err = c.services("app")
+		require.NoError(t, err)
+		assert.Equal(t, uint64(2), c.stats().Misses)
+	})
+}
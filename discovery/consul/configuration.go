@@ -17,6 +17,8 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"os"
+	"strings"
 	"time"
 
 	consul "github.com/hashicorp/consul/api"
@@ -36,6 +38,9 @@ type IntermediateConfig struct {
 	AdvancedRegistration *AgentServiceRegistration // advanced use-case
 	SelfRegister         bool
 	DaprPortMetaKey      string
+	Cache                *CacheConfig
+	AntiEntropy          *AntiEntropyConfig
+	HealthCheck          *HealthCheckConfig
 }
 
 type configSpec struct {
@@ -47,6 +52,9 @@ type configSpec struct {
 	AdvancedRegistration *consul.AgentServiceRegistration // advanced use-case
 	SelfRegister         bool
 	DaprPortMetaKey      string
+	Cache                *CacheConfig
+	AntiEntropy          *AntiEntropyConfig
+	HealthCheck          *HealthCheckConfig
 }
 
 func parseConfig(rawConfig interface{}) (configSpec, error) {
@@ -69,6 +77,10 @@ func parseConfig(rawConfig interface{}) (configSpec, error) {
 		return result, fmt.Errorf("error deserializing to configSpec: %w", err)
 	}
 
+	if err := configuration.Client.loadSecrets(); err != nil {
+		return result, err
+	}
+
 	result = mapConfig(configuration)
 
 	return result, nil
@@ -84,6 +96,9 @@ func mapConfig(config IntermediateConfig) configSpec {
 		AdvancedRegistration: mapAdvancedRegistration(config.AdvancedRegistration),
 		SelfRegister:         config.SelfRegister,
 		DaprPortMetaKey:      config.DaprPortMetaKey,
+		Cache:                config.Cache,
+		AntiEntropy:          config.AntiEntropy,
+		HealthCheck:          config.HealthCheck,
 	}
 }
 
@@ -111,6 +126,8 @@ func mapClientConfig(config *Config) *consul.Config {
 		WaitTime:   config.WaitTime,
 		Token:      config.Token,
 		TokenFile:  config.TokenFile,
+		Namespace:  config.Namespace,
+		Partition:  config.Partition,
 		TLSConfig: consul.TLSConfig{
 			Address:            config.TLSConfig.Address,
 			CAFile:             config.TLSConfig.CAFile,
@@ -122,6 +139,31 @@ func mapClientConfig(config *Config) *consul.Config {
 	}
 }
 
+// loadSecrets reads the token and the basic auth password from the environment variables or files they
+// reference. Values set inline take precedence.
+func (config *Config) loadSecrets() error {
+	if config == nil {
+		return nil
+	}
+	if config.Token == "" && config.TokenEnv != "" {
+		config.Token = os.Getenv(config.TokenEnv)
+	}
+	auth := config.HTTPAuth
+	if auth == nil || auth.Password != "" {
+		return nil
+	}
+	if auth.PasswordEnv != "" {
+		auth.Password = os.Getenv(auth.PasswordEnv)
+	} else if auth.PasswordFile != "" {
+		b, err := os.ReadFile(auth.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("error reading consul http auth password file: %w", err)
+		}
+		auth.Password = strings.TrimSpace(string(b))
+	}
+	return nil
+}
+
 func mapChecks(config []*AgentServiceCheck) []*consul.AgentServiceCheck {
 	if config == nil {
 		return nil
@@ -171,6 +213,8 @@ func mapQueryOptions(config *QueryOptions) *consul.QueryOptions {
 	}
 
 	return &consul.QueryOptions{
+		Namespace:         config.Namespace,
+		Partition:         config.Partition,
 		Datacenter:        config.Datacenter,
 		AllowStale:        config.AllowStale,
 		RequireConsistent: config.RequireConsistent,
@@ -310,6 +354,8 @@ func mapAdvancedRegistration(config *AgentServiceRegistration) *consul.AgentServ
 	mapped := &consul.AgentServiceRegistration{
 		Kind:              consul.ServiceKind(config.Kind),
 		ID:                config.ID,
+		Namespace:         config.Namespace,
+		Partition:         config.Partition,
 		Name:              config.Name,
 		Tags:              config.Tags,
 		Port:              config.Port,
@@ -328,8 +374,10 @@ func mapAdvancedRegistration(config *AgentServiceRegistration) *consul.AgentServ
 }
 
 type HTTPBasicAuth struct {
-	Username string
-	Password string
+	Username     string
+	Password     string
+	PasswordFile string
+	PasswordEnv  string
 }
 
 type Config struct {
@@ -340,6 +388,9 @@ type Config struct {
 	WaitTime   time.Duration
 	Token      string
 	TokenFile  string
+	TokenEnv   string
+	Namespace  string
+	Partition  string
 	TLSConfig  TLSConfig
 }
 
@@ -399,6 +450,8 @@ type QueryOptions struct {
 type AgentServiceRegistration struct {
 	Kind              string // original: type ServiceKind string
 	ID                string
+	Namespace         string
+	Partition         string
 	Name              string
 	Tags              []string
 	Port              int
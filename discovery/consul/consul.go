@@ -19,6 +19,7 @@ import (
 	"math/big"
 	"net"
 	"strconv"
+	"strings"
 	"sync"
 
 	nr "github.com/dapr/components-contrib/nameresolution"
@@ -28,7 +29,11 @@ import (
 	"github.com/taction/http-provider-go/discovery"
 )
 
-const daprMeta string = "DAPR_PORT" // default key for DAPR_PORT metadata
+const (
+	daprMeta       string = "DAPR_PORT" // default key for DAPR_PORT metadata
+	zoneMetaKey    string = "zone"      // key of the locality of an instance
+	versionMetaKey string = "version"   // key of the version of an instance
+)
 
 type client struct {
 	*consul.Client
@@ -63,6 +68,8 @@ type agentInterface interface {
 	Self() (map[string]map[string]interface{}, error)
 	ServiceRegister(service *consul.AgentServiceRegistration) error
 	ServiceDeregister(serviceID string) error
+	Services() (map[string]*consul.AgentService, error)
+	UpdateTTL(checkID, output, status string) error
 }
 
 type healthInterface interface {
@@ -75,6 +82,13 @@ type Resolver struct {
 	client clientInterface
 	l      sync.Mutex
 	apps   map[string]discovery.App
+	cache  *endpointCache
+	owner  string
+	done   chan struct{}
+	closed sync.Once
+	// healthy is the health reported for ttl checks by instance id.
+	healthy   map[string]bool
+	reportNow chan struct{}
 }
 
 type resolverConfig struct {
@@ -82,6 +96,11 @@ type resolverConfig struct {
 	QueryOptions    *consul.QueryOptions
 	Registration    *consul.AgentServiceRegistration
 	DaprPortMetaKey string
+	// Template is the base of the registration of every app registered with RegisterToDiscovery.
+	Template    *consul.AgentServiceRegistration
+	Cache       *CacheConfig
+	AntiEntropy *AntiEntropyConfig
+	HealthCheck *HealthCheckConfig
 }
 
 // NewResolver creates Consul name Resolver.
@@ -91,18 +110,28 @@ func NewResolver(logger logger.Logger) *Resolver {
 
 func newResolver(logger logger.Logger, resolverConfig resolverConfig, client clientInterface) *Resolver {
 	return &Resolver{
-		logger: logger,
-		config: resolverConfig,
-		client: client,
-		apps:   make(map[string]discovery.App),
+		logger:    logger,
+		config:    resolverConfig,
+		client:    client,
+		apps:      make(map[string]discovery.App),
+		owner:     defaultOwner(),
+		done:      make(chan struct{}),
+		healthy:   make(map[string]bool),
+		reportNow: make(chan struct{}, 1),
 	}
 }
 
 // Init will configure component. It will also register service or validate client connection based on config.
+// Without the dapr app properties in metadata, the registration config is only used as the template of the
+// apps registered with RegisterToDiscovery.
 func (r *Resolver) Init(metadata nr.Metadata) error {
 	var err error
 
-	r.config, err = getConfig(metadata)
+	if _, ok := metadata.Properties[nr.AppID]; ok {
+		r.config, err = getConfig(metadata)
+	} else {
+		r.config, err = getTemplateConfig(metadata)
+	}
 	if err != nil {
 		return err
 	}
@@ -110,16 +139,51 @@ func (r *Resolver) Init(metadata nr.Metadata) error {
 	if err = r.client.InitClient(r.config.Client); err != nil {
 		return fmt.Errorf("failed to init consul client: %w", err)
 	}
+	if r.config.Cache != nil && r.config.Cache.Enabled {
+		r.cache = newEndpointCache(*r.config.Cache, r.client.Health, func() *consul.QueryOptions {
+			return r.config.QueryOptions
+		}, r.logger)
+	}
+
+	// register service to consul
+	if r.config.Registration != nil {
+		if err := r.client.Agent().ServiceRegister(r.config.Registration); err != nil {
+			return fmt.Errorf("failed to register consul service: %w", err)
+		}
+
+		r.logger.Infof("service:%s registered on consul agent", r.config.Registration.Name)
+	} else if _, err := r.client.Agent().Self(); err != nil {
+		return fmt.Errorf("failed check on consul agent: %w", err)
+	}
+
+	// linked apps are only registered in template mode
+	if r.config.Template != nil {
+		ae := AntiEntropyConfig{}
+		if r.config.AntiEntropy != nil {
+			ae = *r.config.AntiEntropy
+		}
+		if ae.Owner != "" {
+			r.owner = ae.Owner
+		}
+		if ae.Interval.Duration <= 0 {
+			ae.Interval.Duration = defaultAntiEntropyInterval
+		}
+		if !ae.Disabled {
+			go r.antiEntropy(ae.Interval.Duration)
+		}
+		if r.ttlCheck() {
+			go r.reportTTL()
+		}
+	}
 
 	return nil
 }
 
 // ResolveID resolves name to address via consul.
 func (r *Resolver) ResolveID(req nr.ResolveRequest) (string, error) {
-	cfg := r.config
-	services, _, err := r.client.Health().Service(req.ID, "", true, cfg.QueryOptions)
+	services, err := r.healthyServices(req.ID)
 	if err != nil {
-		return "", fmt.Errorf("failed to query healthy consul services: %w", err)
+		return "", err
 	}
 
 	if len(services) == 0 {
@@ -137,101 +201,377 @@ func (r *Resolver) ResolveID(req nr.ResolveRequest) (string, error) {
 		return services
 	}
 
-	svc := shuffle(services)[0]
+	return r.address(req.ID, shuffle(services)[0])
+}
 
-	addr := ""
+// ResolveEndpoints returns every healthy instance of the app id, weighted by their passing weight,
+// located in the zone and versioned by the version of their service meta.
+func (r *Resolver) ResolveEndpoints(req nr.ResolveRequest) ([]discovery.Endpoint, error) {
+	services, err := r.healthyServices(req.ID)
+	if err != nil {
+		return nil, err
+	}
 
-	if port, ok := svc.Service.Meta[cfg.DaprPortMetaKey]; ok {
-		if svc.Service.Address != "" {
-			addr = fmt.Sprintf("%s:%s", svc.Service.Address, port)
-		} else if svc.Node.Address != "" {
-			addr = fmt.Sprintf("%s:%s", svc.Node.Address, port)
-		} else {
-			return "", fmt.Errorf("no healthy services found with AppID:%s", req.ID)
+	endpoints := make([]discovery.Endpoint, 0, len(services))
+	for _, svc := range services {
+		addr, err := r.address(req.ID, svc)
+		if err != nil {
+			r.logger.Warnf("skip instance %s of %s: %s", svc.Service.ID, req.ID, err)
+			continue
 		}
-	} else {
-		return "", fmt.Errorf("target service AppID:%s found but DAPR_PORT missing from meta", req.ID)
+		weight := svc.Service.Weights.Passing
+		if weight <= 0 {
+			weight = 1
+		}
+		endpoints = append(endpoints, discovery.Endpoint{
+			Address:  addr,
+			Weight:   weight,
+			Locality: svc.Service.Meta[zoneMetaKey],
+			Version:  svc.Service.Meta[versionMetaKey],
+		})
+	}
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("no healthy services found with AppID:%s", req.ID)
+	}
+
+	return endpoints, nil
+}
+
+func (r *Resolver) address(appID string, svc *consul.ServiceEntry) (string, error) {
+	port, ok := svc.Service.Meta[r.config.DaprPortMetaKey]
+	if !ok {
+		return "", fmt.Errorf("target service AppID:%s found but DAPR_PORT missing from meta", appID)
 	}
+	if svc.Service.Address != "" {
+		return fmt.Sprintf("%s:%s", svc.Service.Address, port), nil
+	} else if svc.Node != nil && svc.Node.Address != "" {
+		return fmt.Sprintf("%s:%s", svc.Node.Address, port), nil
+	}
+
+	return "", fmt.Errorf("no healthy services found with AppID:%s", appID)
+}
 
-	return addr, nil
+// healthyServices returns the healthy instances of appID, from the endpoint cache when it is enabled.
+func (r *Resolver) healthyServices(appID string) ([]*consul.ServiceEntry, error) {
+	if r.cache != nil {
+		services, err := r.cache.services(appID)
+		if err != nil {
+			return nil, err
+		}
+		// ResolveID shuffles the result, don't share the cached slice
+		return append([]*consul.ServiceEntry{}, services...), nil
+	}
+	services, _, err := r.client.Health().Service(appID, "", true, r.config.QueryOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query healthy consul services: %w", err)
+	}
+	return services, nil
+}
+
+// CacheStats returns the counters of the endpoint cache, ok is false when the cache is disabled.
+func (r *Resolver) CacheStats() (stats CacheStats, ok bool) {
+	if r.cache == nil {
+		return stats, false
+	}
+	return r.cache.stats(), true
+}
+
+// FlushCache forces the next resolution of every cached app id to query consul.
+func (r *Resolver) FlushCache() {
+	if r.cache != nil {
+		r.cache.flush()
+	}
+}
+
+// Close stops the anti-entropy loop and the watches of the endpoint cache.
+func (r *Resolver) Close() error {
+	r.closed.Do(func() {
+		close(r.done)
+		if r.cache != nil {
+			r.cache.close()
+		}
+	})
+	return nil
 }
 
 func (r *Resolver) RegisterToDiscovery(a discovery.App) (err error) {
-	uname := a.AppID + a.Host
+	registration, err := r.registration(a)
+	if err != nil {
+		return err
+	}
+
+	r.l.Lock()
+	defer r.l.Unlock()
+	if err := r.client.Agent().ServiceRegister(registration); err != nil {
+		return fmt.Errorf("failed to register consul service: %w", err)
+	}
+	r.apps[a.InstanceID()] = a
+
+	return nil
+}
+
+// registration fills the template with the id, address and port of a, its checks may use the
+// {{app_id}}, {{instance_id}} and {{address}} placeholders. Check ids need {{instance_id}}, otherwise the
+// instances of an app share one check. The service name is the app id so that the instances registered
+// by several providers are resolved together.
+func (r *Resolver) registration(a discovery.App) (*consul.AgentServiceRegistration, error) {
 	host, port, err := net.SplitHostPort(a.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address of app %s: %w", a.AppID, err)
+	}
 	pint, err := strconv.Atoi(port)
-	// http health check
-	//checks := []*consul.AgentServiceCheck{
-	//	{
-	//		Name:     "Wasm Http Provider Health Status",
-	//		CheckID:  fmt.Sprintf("wasmHealth:%s", a.AppID),
-	//		Interval: "15s",
-	//		HTTP:     fmt.Sprintf("http://%s/v1.0/healthz", a.Address),
-	//	},
-	//}
-	checks := []*consul.AgentServiceCheck{
-		{
-			Name:                           "Wasm Http Provider Health Status",
-			CheckID:                        fmt.Sprintf("wasmHealth:%s", a.AppID),
-			Interval:                       "15s",
-			Timeout:                        "5s",
-			GRPC:                           fmt.Sprintf(a.Address),
-			DeregisterCriticalServiceAfter: "120s",
-		},
-	}
-	Instance := consul.AgentServiceRegistration{
-		ID:      a.AppID,
-		Name:    uname,
-		Address: host,
-		Port:    pint,
-		Checks:  checks,
-		Tags:    []string{"wasmcloud"},
-		Meta:    map[string]string{nr.DaprPort: port},
+	if err != nil {
+		return nil, fmt.Errorf("invalid port of app %s: %w", a.AppID, err)
 	}
 
-	if err := r.client.Agent().ServiceRegister(&Instance); err != nil {
-		return fmt.Errorf("failed to register consul service: %w", err)
+	registration := consul.AgentServiceRegistration{}
+	if r.config.Template != nil {
+		registration = *r.config.Template
+	}
+	registration.ID = a.InstanceID()
+	registration.Name = a.AppID
+	registration.Address = host
+	registration.Port = pint
+	registration.Tags = append([]string{"wasmcloud"}, registration.Tags...)
+	if a.Version != "" {
+		registration.Tags = append(registration.Tags, versionMetaKey+"="+a.Version)
 	}
 
-	r.l.Lock()
-	r.apps[a.AppID] = a
-	r.l.Unlock()
+	meta := map[string]string{}
+	for k, v := range registration.Meta {
+		meta[k] = v
+	}
+	metaKey := r.config.DaprPortMetaKey
+	if metaKey == "" {
+		metaKey = daprMeta
+	}
+	meta[metaKey] = port
+	meta[ownerMetaKey] = r.owner
+	if a.Version != "" {
+		meta[versionMetaKey] = a.Version
+	}
+	registration.Meta = meta
 
-	return nil
+	replacer := strings.NewReplacer("{{app_id}}", a.AppID, "{{instance_id}}", registration.ID, "{{address}}", a.Address)
+	checks := consul.AgentServiceChecks{}
+	templates := registration.Checks
+	if registration.Check != nil {
+		templates = append(consul.AgentServiceChecks{registration.Check}, templates...)
+	}
+	for _, c := range templates {
+		check := *c
+		check.CheckID = replacer.Replace(check.CheckID)
+		check.Name = replacer.Replace(check.Name)
+		check.HTTP = replacer.Replace(check.HTTP)
+		check.GRPC = replacer.Replace(check.GRPC)
+		check.TCP = replacer.Replace(check.TCP)
+		checks = append(checks, &check)
+	}
+	if len(checks) == 0 {
+		checks = consul.AgentServiceChecks{r.defaultCheck(a, registration.ID)}
+	}
+	registration.Checks = checks
+	registration.Check = nil
+
+	return &registration, nil
 }
 
-func (r *Resolver) RemoveFromDiscovery(appID string) {
+func (r *Resolver) RemoveFromDiscovery(a discovery.App) {
+	id := a.InstanceID()
 	r.l.Lock()
-	delete(r.apps, appID)
-	r.l.Unlock()
-	err := r.client.Agent().ServiceDeregister(appID)
+	defer r.l.Unlock()
+	delete(r.apps, id)
+	delete(r.healthy, id)
+	err := r.client.Agent().ServiceDeregister(id)
 	if err != nil {
-		fmt.Printf("failed to deregister consul service %s: %s", appID, err)
+		r.logger.Warnf("failed to deregister consul service %s: %s", id, err)
 	}
 }
 
 // getConfig configuration from metadata, defaults are best suited for self-hosted mode.
 func getConfig(metadata nr.Metadata) (resolverConfig, error) {
+	var daprPort string
+	var ok bool
 	var err error
+	resolverCfg := resolverConfig{}
+
+	props := metadata.Properties
+
+	if daprPort, ok = props[nr.DaprPort]; !ok {
+		return resolverCfg, fmt.Errorf("metadata property missing: %s", nr.DaprPort)
+	}
+
+	cfg, err := parseConfig(metadata.Configuration)
+	if err != nil {
+		return resolverCfg, err
+	}
+
+	// set DaprPortMetaKey used for registring DaprPort and resolving from Consul
+	if cfg.DaprPortMetaKey == "" {
+		resolverCfg.DaprPortMetaKey = daprMeta
+	} else {
+		resolverCfg.DaprPortMetaKey = cfg.DaprPortMetaKey
+	}
+
+	resolverCfg.Client = getClientConfig(cfg)
+	if resolverCfg.Registration, err = getRegistrationConfig(cfg, props); err != nil {
+		return resolverCfg, err
+	}
+	resolverCfg.QueryOptions = getQueryOptionsConfig(cfg)
+	resolverCfg.Cache = cfg.Cache
+
+	// if registering, set DaprPort in meta, needed for resolution
+	if resolverCfg.Registration != nil {
+		if resolverCfg.Registration.Meta == nil {
+			resolverCfg.Registration.Meta = map[string]string{}
+		}
+
+		resolverCfg.Registration.Meta[resolverCfg.DaprPortMetaKey] = daprPort
+	}
+
+	return resolverCfg, nil
+}
+
+// getTemplateConfig configuration from metadata for registering linked apps, SelfRegister is implied
+// and AdvancedRegistration is the template of every registration.
+func getTemplateConfig(metadata nr.Metadata) (resolverConfig, error) {
 	resolverCfg := resolverConfig{DaprPortMetaKey: daprMeta}
 
 	cfg, err := parseConfig(metadata.Configuration)
 	if err != nil {
 		return resolverCfg, err
 	}
+	if cfg.DaprPortMetaKey != "" {
+		resolverCfg.DaprPortMetaKey = cfg.DaprPortMetaKey
+	}
 	resolverCfg.Client = getClientConfig(cfg)
 	resolverCfg.QueryOptions = getQueryOptionsConfig(cfg)
+	resolverCfg.Cache = cfg.Cache
+	resolverCfg.AntiEntropy = cfg.AntiEntropy
+	if cfg.HealthCheck != nil {
+		if err := cfg.HealthCheck.withDefaults().validate(); err != nil {
+			return resolverCfg, err
+		}
+		resolverCfg.HealthCheck = cfg.HealthCheck
+	}
+	if cfg.AdvancedRegistration != nil {
+		resolverCfg.Template = cfg.AdvancedRegistration
+	} else {
+		resolverCfg.Template = &consul.AgentServiceRegistration{
+			Checks: cfg.Checks,
+			Tags:   cfg.Tags,
+			Meta:   cfg.Meta,
+		}
+	}
 
 	return resolverCfg, nil
 }
 
 func getClientConfig(cfg configSpec) *consul.Config {
 	// If no client config use library defaults
-	if cfg.Client != nil {
-		return cfg.Client
+	defaults := consul.DefaultConfig()
+	if cfg.Client == nil {
+		return defaults
+	}
+
+	// the library defaults come from the CONSUL_* environment variables, keep them for what is not configured
+	c := *cfg.Client
+	if c.Address == "" {
+		c.Address = defaults.Address
+	}
+	if c.Scheme == "" {
+		c.Scheme = defaults.Scheme
+	}
+	if c.Datacenter == "" {
+		c.Datacenter = defaults.Datacenter
+	}
+	if c.HttpAuth == nil {
+		c.HttpAuth = defaults.HttpAuth
+	}
+	if c.Token == "" && c.TokenFile == "" {
+		c.Token, c.TokenFile = defaults.Token, defaults.TokenFile
+	}
+	if c.Namespace == "" {
+		c.Namespace = defaults.Namespace
+	}
+	if c.Partition == "" {
+		c.Partition = defaults.Partition
+	}
+	tls, defaultTLS := &c.TLSConfig, defaults.TLSConfig
+	if tls.Address == "" {
+		tls.Address = defaultTLS.Address
+	}
+	if tls.CAFile == "" && tls.CAPath == "" {
+		tls.CAFile, tls.CAPath = defaultTLS.CAFile, defaultTLS.CAPath
+	}
+	if tls.CertFile == "" && tls.KeyFile == "" {
+		tls.CertFile, tls.KeyFile = defaultTLS.CertFile, defaultTLS.KeyFile
+	}
+	tls.InsecureSkipVerify = tls.InsecureSkipVerify || defaultTLS.InsecureSkipVerify
+	c.Transport = defaults.Transport
+
+	return &c
+}
+
+func getRegistrationConfig(cfg configSpec, props map[string]string) (*consul.AgentServiceRegistration, error) {
+	// if advanced registration configured ignore other registration related configs
+	if cfg.AdvancedRegistration != nil {
+		return cfg.AdvancedRegistration, nil
+	} else if !cfg.SelfRegister {
+		return nil, nil
 	}
 
-	return consul.DefaultConfig()
+	var appID string
+	var appPort string
+	var host string
+	var httpPort string
+	var ok bool
+
+	if appID, ok = props[nr.AppID]; !ok {
+		return nil, fmt.Errorf("metadata property missing: %s", nr.AppID)
+	}
+
+	if appPort, ok = props[nr.AppPort]; !ok {
+		return nil, fmt.Errorf("metadata property missing: %s", nr.AppPort)
+	}
+
+	if host, ok = props[nr.HostAddress]; !ok {
+		return nil, fmt.Errorf("metadata property missing: %s", nr.HostAddress)
+	}
+
+	if httpPort, ok = props[nr.DaprHTTPPort]; !ok {
+		return nil, fmt.Errorf("metadata property missing: %s", nr.DaprHTTPPort)
+	} else if _, err := strconv.ParseUint(httpPort, 10, 0); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", nr.DaprHTTPPort, err)
+	}
+
+	id := appID + "-" + host + "-" + httpPort
+	// if no health checks configured add dapr sidecar health check by default
+	if len(cfg.Checks) == 0 {
+		cfg.Checks = []*consul.AgentServiceCheck{
+			{
+				Name:     "Dapr Health Status",
+				CheckID:  fmt.Sprintf("daprHealth:%s", id),
+				Interval: "15s",
+				HTTP:     fmt.Sprintf("http://%s/v1.0/healthz", net.JoinHostPort(host, httpPort)),
+			},
+		}
+	}
+
+	appPortInt, err := strconv.Atoi(appPort)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", nr.AppPort, err)
+	}
+
+	return &consul.AgentServiceRegistration{
+		ID:      id,
+		Name:    appID,
+		Address: host,
+		Port:    appPortInt,
+		Checks:  cfg.Checks,
+		Tags:    cfg.Tags,
+		Meta:    cfg.Meta,
+	}, nil
 }
 
 func getQueryOptionsConfig(cfg configSpec) *consul.QueryOptions {
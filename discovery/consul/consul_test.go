@@ -16,15 +16,22 @@ package consul
 import (
 	"fmt"
 	"net"
+	"os"
+	"path/filepath"
 	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	consul "github.com/hashicorp/consul/api"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/dapr/components-contrib/metadata"
 	nr "github.com/dapr/components-contrib/nameresolution"
 	"github.com/dapr/kit/logger"
+
+	"github.com/taction/http-provider-go/discovery"
 )
 
 type mockClient struct {
@@ -68,6 +75,7 @@ type mockAgent struct {
 	selfResult            map[string]map[string]interface{}
 	serviceRegisterCalled int
 	serviceRegisterErr    error
+	serviceRegistered     *consul.AgentServiceRegistration
 }
 
 func (m *mockAgent) Self() (map[string]map[string]interface{}, error) {
@@ -78,6 +86,7 @@ func (m *mockAgent) Self() (map[string]map[string]interface{}, error) {
 
 func (m *mockAgent) ServiceRegister(service *consul.AgentServiceRegistration) error {
 	m.serviceRegisterCalled++
+	m.serviceRegistered = service
 
 	return m.serviceRegisterErr
 }
@@ -87,6 +96,14 @@ func (m *mockAgent) ServiceDeregister(id string) error {
 	return nil
 }
 
+func (m *mockAgent) Services() (map[string]*consul.AgentService, error) {
+	return nil, nil
+}
+
+func (m *mockAgent) UpdateTTL(checkID, output, status string) error {
+	return nil
+}
+
 func TestInit(t *testing.T) {
 	t.Parallel()
 
@@ -169,6 +186,309 @@ func TestInit(t *testing.T) {
 	}
 }
 
+func TestRegisterToDiscovery(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should register with default check", func(t *testing.T) {
+		t.Parallel()
+		var mock mockClient
+		resolver := newResolver(logger.NewLogger("test"), resolverConfig{}, &mock)
+		assert.NoError(t, resolver.Init(nr.Metadata{Configuration: nil}))
+
+		assert.NoError(t, resolver.RegisterToDiscovery(discovery.App{AppID: "test-app", Address: "10.0.0.1:8888"}))
+		actual := mock.mockAgent.serviceRegistered
+		assert.Equal(t, 1, mock.mockAgent.selfCalled)
+		assert.Equal(t, "test-app-10.0.0.1-8888", actual.ID)
+		assert.Equal(t, "test-app", actual.Name)
+		assert.Equal(t, "10.0.0.1", actual.Address)
+		assert.Equal(t, 8888, actual.Port)
+		assert.Equal(t, "8888", actual.Meta["DAPR_PORT"])
+		assert.Equal(t, []string{"wasmcloud"}, actual.Tags)
+		assert.Equal(t, 1, len(actual.Checks))
+		assert.Equal(t, "10.0.0.1:8888", actual.Checks[0].GRPC)
+	})
+
+	t.Run("should register an http check for http links", func(t *testing.T) {
+		t.Parallel()
+		var mock mockClient
+		resolver := newResolver(logger.NewLogger("test"), resolverConfig{}, &mock)
+		assert.NoError(t, resolver.Init(nr.Metadata{Configuration: map[string]interface{}{
+			"healthCheck": map[string]interface{}{"interval": "5s", "deregisterCriticalServiceAfter": "1m"},
+		}}))
+
+		assert.NoError(t, resolver.RegisterToDiscovery(discovery.App{AppID: "test-app", Address: "10.0.0.1:8888", Protocol: discovery.ProtocolHTTP}))
+		check := mock.mockAgent.serviceRegistered.Checks[0]
+		assert.Equal(t, "http://10.0.0.1:8888/v1.0/healthz", check.HTTP)
+		assert.Empty(t, check.GRPC)
+		assert.Equal(t, "5s", check.Interval)
+		assert.Equal(t, "5s", check.Timeout)
+		assert.Equal(t, "1m", check.DeregisterCriticalServiceAfter)
+	})
+
+	t.Run("should register the version", func(t *testing.T) {
+		t.Parallel()
+		var mock mockClient
+		resolver := newResolver(logger.NewLogger("test"), resolverConfig{}, &mock)
+		assert.NoError(t, resolver.Init(nr.Metadata{Configuration: nil}))
+
+		assert.NoError(t, resolver.RegisterToDiscovery(discovery.App{AppID: "test-app", Address: "10.0.0.1:8888", Version: "v2"}))
+		actual := mock.mockAgent.serviceRegistered
+		assert.Equal(t, []string{"wasmcloud", "version=v2"}, actual.Tags)
+		assert.Equal(t, "v2", actual.Meta["version"])
+	})
+
+	t.Run("should reject unknown check types", func(t *testing.T) {
+		t.Parallel()
+		var mock mockClient
+		resolver := newResolver(logger.NewLogger("test"), resolverConfig{}, &mock)
+		assert.Error(t, resolver.Init(nr.Metadata{Configuration: map[string]interface{}{
+			"healthCheck": map[string]interface{}{"type": "tcp"},
+		}}))
+	})
+
+	t.Run("should honour configured tags, meta and checks", func(t *testing.T) {
+		t.Parallel()
+		var mock mockClient
+		resolver := newResolver(logger.NewLogger("test"), resolverConfig{}, &mock)
+		assert.NoError(t, resolver.Init(nr.Metadata{Configuration: configSpec{
+			Tags: []string{"test"},
+			Meta: map[string]string{"APP_PORT": "8650"},
+			Checks: []*consul.AgentServiceCheck{
+				{
+					CheckID:  "check:{{app_id}}",
+					Interval: "5s",
+					TCP:      "{{address}}",
+				},
+			},
+			DaprPortMetaKey: "random_key",
+		}}))
+
+		assert.NoError(t, resolver.RegisterToDiscovery(discovery.App{AppID: "test-app", Address: "10.0.0.1:8888"}))
+		actual := mock.mockAgent.serviceRegistered
+		assert.Equal(t, []string{"wasmcloud", "test"}, actual.Tags)
+		assert.Equal(t, map[string]string{"APP_PORT": "8650", "random_key": "8888", ownerMetaKey: resolver.owner}, actual.Meta)
+		assert.Equal(t, "check:test-app", actual.Checks[0].CheckID)
+		assert.Equal(t, "10.0.0.1:8888", actual.Checks[0].TCP)
+	})
+
+	t.Run("should use advanced registration as template", func(t *testing.T) {
+		t.Parallel()
+		var mock mockClient
+		resolver := newResolver(logger.NewLogger("test"), resolverConfig{}, &mock)
+		assert.NoError(t, resolver.Init(nr.Metadata{Configuration: configSpec{
+			AdvancedRegistration: &consul.AgentServiceRegistration{
+				Weights: &consul.AgentWeights{Passing: 10, Warning: 1},
+				Check:   &consul.AgentServiceCheck{HTTP: "http://{{address}}/v1.0/healthz"},
+			},
+			Tags: []string{"ignored"},
+		}}))
+		assert.Equal(t, 0, mock.mockAgent.serviceRegisterCalled)
+
+		assert.NoError(t, resolver.RegisterToDiscovery(discovery.App{AppID: "test-app", Address: "10.0.0.1:8888"}))
+		actual := mock.mockAgent.serviceRegistered
+		assert.Equal(t, 10, actual.Weights.Passing)
+		assert.Equal(t, []string{"wasmcloud"}, actual.Tags)
+		assert.Equal(t, "http://10.0.0.1:8888/v1.0/healthz", actual.Checks[0].HTTP)
+	})
+}
+
+// catalogClient is a consul agent and catalog shared by several resolvers.
+type catalogClient struct {
+	l        sync.Mutex
+	services map[string]*consul.AgentServiceRegistration
+	ttl      map[string]string
+}
+
+func (c *catalogClient) InitClient(config *consul.Config) error { return nil }
+func (c *catalogClient) Agent() agentInterface                  { return c }
+func (c *catalogClient) Health() healthInterface                { return c }
+
+func (c *catalogClient) Self() (map[string]map[string]interface{}, error) { return nil, nil }
+
+func (c *catalogClient) ServiceRegister(service *consul.AgentServiceRegistration) error {
+	c.l.Lock()
+	defer c.l.Unlock()
+	c.services[service.ID] = service
+	return nil
+}
+
+func (c *catalogClient) ServiceDeregister(id string) error {
+	c.l.Lock()
+	defer c.l.Unlock()
+	delete(c.services, id)
+	return nil
+}
+
+func (c *catalogClient) Services() (map[string]*consul.AgentService, error) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	services := map[string]*consul.AgentService{}
+	for id, s := range c.services {
+		services[id] = &consul.AgentService{ID: id, Service: s.Name, Meta: s.Meta}
+	}
+	return services, nil
+}
+
+func (c *catalogClient) UpdateTTL(checkID, output, status string) error {
+	c.l.Lock()
+	defer c.l.Unlock()
+	if c.ttl == nil {
+		c.ttl = map[string]string{}
+	}
+	c.ttl[checkID] = status
+	return nil
+}
+
+func (c *catalogClient) ttlStatus(checkID string) string {
+	c.l.Lock()
+	defer c.l.Unlock()
+	return c.ttl[checkID]
+}
+
+func (c *catalogClient) Service(service, tag string, passingOnly bool, q *consul.QueryOptions) ([]*consul.ServiceEntry, *consul.QueryMeta, error) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	var entries []*consul.ServiceEntry
+	for _, s := range c.services {
+		if s.Name == service {
+			entries = append(entries, &consul.ServiceEntry{Service: &consul.AgentService{ID: s.ID, Service: s.Name, Address: s.Address, Port: s.Port, Meta: s.Meta}})
+		}
+	}
+	return entries, &consul.QueryMeta{}, nil
+}
+
+func TestReplicas(t *testing.T) {
+	catalog := &catalogClient{services: map[string]*consul.AgentServiceRegistration{}}
+	newProviderResolver := func() *Resolver {
+		r := newResolver(logger.NewLogger("test"), resolverConfig{}, catalog)
+		require.NoError(t, r.Init(nr.Metadata{Configuration: nil}))
+		return r
+	}
+	first, second, peer := newProviderResolver(), newProviderResolver(), newProviderResolver()
+
+	one := discovery.App{AppID: "test-app", Address: "10.0.0.1:8888", Host: "host-1"}
+	two := discovery.App{AppID: "test-app", Address: "10.0.0.2:8888", Host: "host-2"}
+	require.NoError(t, first.RegisterToDiscovery(one))
+	require.NoError(t, second.RegisterToDiscovery(two))
+	assert.Len(t, catalog.services, 2)
+
+	// a dapr peer resolving the app id spreads its calls over both providers
+	resolved := map[string]int{}
+	for i := 0; i < 100; i++ {
+		addr, err := peer.ResolveID(nr.ResolveRequest{ID: "test-app"})
+		require.NoError(t, err)
+		resolved[addr]++
+	}
+	assert.Len(t, resolved, 2)
+	assert.Greater(t, resolved["10.0.0.1:8888"], 0)
+	assert.Greater(t, resolved["10.0.0.2:8888"], 0)
+
+	endpoints, err := peer.ResolveEndpoints(nr.ResolveRequest{ID: "test-app"})
+	require.NoError(t, err)
+	assert.Len(t, endpoints, 2)
+
+	// removing one replica leaves the other registered
+	first.RemoveFromDiscovery(one)
+	for i := 0; i < 10; i++ {
+		addr, err := peer.ResolveID(nr.ResolveRequest{ID: "test-app"})
+		require.NoError(t, err)
+		assert.Equal(t, "10.0.0.2:8888", addr)
+	}
+}
+
+func TestTTLCheck(t *testing.T) {
+	catalog := &catalogClient{services: map[string]*consul.AgentServiceRegistration{}}
+	r := newResolver(logger.NewLogger("test"), resolverConfig{}, catalog)
+	require.NoError(t, r.Init(nr.Metadata{Configuration: map[string]interface{}{
+		"healthCheck": map[string]interface{}{"type": "ttl", "ttl": "1m"},
+	}}))
+	defer r.Close()
+
+	app := discovery.App{AppID: "test-app", Address: "10.0.0.1:8888"}
+	require.NoError(t, r.RegisterToDiscovery(app))
+	check := catalog.services[app.InstanceID()].Checks[0]
+	assert.Equal(t, "1m", check.TTL)
+	assert.Equal(t, consul.HealthCritical, check.Status)
+	assert.Empty(t, check.Interval)
+
+	id := checkID(app.InstanceID())
+	r.SetHealth(app, true)
+	assert.Eventually(t, func() bool { return catalog.ttlStatus(id) == consul.HealthPassing }, time.Second, time.Millisecond)
+	r.SetHealth(app, false)
+	assert.Eventually(t, func() bool { return catalog.ttlStatus(id) == consul.HealthCritical }, time.Second, time.Millisecond)
+}
+
+// configured checks are left to consul even when healthCheck asks for a ttl check.
+func TestTTLCheckWithTemplateChecks(t *testing.T) {
+	catalog := &catalogClient{services: map[string]*consul.AgentServiceRegistration{}}
+	r := newResolver(logger.NewLogger("test"), resolverConfig{}, catalog)
+	require.NoError(t, r.Init(nr.Metadata{Configuration: map[string]interface{}{
+		"healthCheck": map[string]interface{}{"type": "ttl", "ttl": "1m"},
+		"checks":      []interface{}{map[string]interface{}{"checkID": "grpc:{{instance_id}}", "grpc": "{{address}}", "interval": "15s"}},
+	}}))
+	defer r.Close()
+
+	app := discovery.App{AppID: "test-app", Address: "10.0.0.1:8888"}
+	require.NoError(t, r.RegisterToDiscovery(app))
+	assert.Equal(t, "grpc:"+app.InstanceID(), catalog.services[app.InstanceID()].Checks[0].CheckID)
+	assert.False(t, r.ttlCheck())
+
+	r.SetHealth(app, true)
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, catalog.ttlStatus(checkID(app.InstanceID())))
+}
+
+func TestReconcile(t *testing.T) {
+	catalog := &catalogClient{services: map[string]*consul.AgentServiceRegistration{}}
+	r := newResolver(logger.NewLogger("test"), resolverConfig{}, catalog)
+	require.NoError(t, r.Init(nr.Metadata{Configuration: map[string]interface{}{
+		"antiEntropy": map[string]interface{}{"owner": "provider-1", "disabled": true},
+	}}))
+	assert.Equal(t, "provider-1", r.owner)
+
+	app := discovery.App{AppID: "test-app", Address: "10.0.0.1:8888"}
+	require.NoError(t, r.RegisterToDiscovery(app))
+	// the agent restarted without the registration, a previous run left an orphan and another
+	// provider registered its own app
+	catalog.services = map[string]*consul.AgentServiceRegistration{
+		"old-app-10.0.0.1-7777":   {ID: "old-app-10.0.0.1-7777", Name: "old-app", Meta: map[string]string{ownerMetaKey: "provider-1"}},
+		"other-app-10.0.0.2-8888": {ID: "other-app-10.0.0.2-8888", Name: "other-app", Meta: map[string]string{ownerMetaKey: "provider-2"}},
+	}
+
+	require.NoError(t, r.reconcile())
+	assert.Contains(t, catalog.services, app.InstanceID())
+	assert.NotContains(t, catalog.services, "old-app-10.0.0.1-7777")
+	assert.Contains(t, catalog.services, "other-app-10.0.0.2-8888")
+
+	r.RemoveFromDiscovery(app)
+	require.NoError(t, r.reconcile())
+	assert.Len(t, catalog.services, 1)
+}
+
+// providers sharing an agent keep the registrations of each other.
+func TestReconcileSharedAgent(t *testing.T) {
+	catalog := &catalogClient{services: map[string]*consul.AgentServiceRegistration{}}
+	newProviderResolver := func() *Resolver {
+		r := newResolver(logger.NewLogger("test"), resolverConfig{}, catalog)
+		require.NoError(t, r.Init(nr.Metadata{Configuration: map[string]interface{}{
+			"antiEntropy": map[string]interface{}{"disabled": true},
+		}}))
+		return r
+	}
+	first, second := newProviderResolver(), newProviderResolver()
+	assert.NotEqual(t, first.owner, second.owner)
+
+	one := discovery.App{AppID: "app-1", Address: "10.0.0.1:8888"}
+	two := discovery.App{AppID: "app-2", Address: "10.0.0.1:9999"}
+	require.NoError(t, first.RegisterToDiscovery(one))
+	require.NoError(t, second.RegisterToDiscovery(two))
+
+	require.NoError(t, first.reconcile())
+	require.NoError(t, second.reconcile())
+	assert.Contains(t, catalog.services, one.InstanceID())
+	assert.Contains(t, catalog.services, two.InstanceID())
+}
+
 func TestResolveID(t *testing.T) {
 	t.Parallel()
 	testConfig := &resolverConfig{
@@ -338,6 +658,37 @@ func TestResolveID(t *testing.T) {
 	}
 }
 
+func TestResolveEndpoints(t *testing.T) {
+	mock := mockClient{
+		mockHealth: mockHealth{
+			serviceResult: []*consul.ServiceEntry{
+				{
+					Service: &consul.AgentService{
+						Address: "10.0.0.1",
+						Meta:    map[string]string{"DAPR_PORT": "50002", "zone": "zone-a"},
+						Weights: consul.AgentWeights{Passing: 3},
+					},
+				},
+				{
+					Node:    &consul.Node{Address: "10.0.0.2"},
+					Service: &consul.AgentService{Meta: map[string]string{"DAPR_PORT": "50002"}},
+				},
+				{
+					Service: &consul.AgentService{ID: "no-port", Address: "10.0.0.3"},
+				},
+			},
+		},
+	}
+	resolver := newResolver(logger.NewLogger("test"), resolverConfig{DaprPortMetaKey: "DAPR_PORT"}, &mock)
+
+	endpoints, err := resolver.ResolveEndpoints(nr.ResolveRequest{ID: "test-app"})
+	require.NoError(t, err)
+	assert.Equal(t, []discovery.Endpoint{
+		{Address: "10.0.0.1:50002", Weight: 3, Locality: "zone-a"},
+		{Address: "10.0.0.2:50002", Weight: 1},
+	}, endpoints)
+}
+
 func TestParseConfig(t *testing.T) {
 	t.Parallel()
 
@@ -1260,3 +1611,59 @@ func getTestPropsWithoutKey(removeKey string) map[string]string {
 
 	return metadata
 }
+
+func TestClientSecrets(t *testing.T) {
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	assert.NoError(t, os.WriteFile(passwordFile, []byte("file-password\n"), 0o600))
+	t.Setenv("TEST_CONSUL_TOKEN", "env-token")
+	t.Setenv(consul.HTTPNamespaceEnvName, "env-namespace")
+
+	cfg, err := parseConfig(map[string]interface{}{
+		"client": map[string]interface{}{
+			"address":   "https://consul.example.com:8501",
+			"tokenEnv":  "TEST_CONSUL_TOKEN",
+			"partition": "team-a",
+			"httpAuth": map[string]interface{}{
+				"username":     "user",
+				"passwordFile": passwordFile,
+			},
+			"tlsConfig": map[string]interface{}{
+				"caFile": "/etc/consul/ca.pem",
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	actual := getClientConfig(cfg)
+	assert.Equal(t, "env-token", actual.Token)
+	assert.Equal(t, "file-password", actual.HttpAuth.Password)
+	assert.Equal(t, "env-namespace", actual.Namespace)
+	assert.Equal(t, "team-a", actual.Partition)
+	assert.Equal(t, "/etc/consul/ca.pem", actual.TLSConfig.CAFile)
+}
+
+func TestCacheConfigDurations(t *testing.T) {
+	cfg, err := parseConfig(map[string]interface{}{
+		"cache": map[string]interface{}{
+			"enabled":     true,
+			"ttl":         "45s",
+			"waitTime":    "1m",
+			"idleTimeout": float64(time.Hour),
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 45*time.Second, cfg.Cache.TTL.Duration)
+	assert.Equal(t, time.Minute, cfg.Cache.WaitTime.Duration)
+	assert.Equal(t, time.Hour, cfg.Cache.IdleTimeout.Duration)
+
+	_, err = parseConfig(map[string]interface{}{"cache": map[string]interface{}{"ttl": "45"}})
+	assert.Error(t, err)
+}
+
+func TestAntiEntropyInterval(t *testing.T) {
+	cfg, err := parseConfig(map[string]interface{}{
+		"antiEntropy": map[string]interface{}{"interval": "2m"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2*time.Minute, cfg.AntiEntropy.Interval.Duration)
+}
@@ -0,0 +1,166 @@
+package consul
+
+import (
+	"fmt"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+
+	"github.com/taction/http-provider-go/discovery"
+)
+
+const (
+	CheckTypeAuto = ""
+	CheckTypeGRPC = "grpc"
+	CheckTypeHTTP = "http"
+	CheckTypeTTL  = "ttl"
+)
+
+const (
+	defaultCheckInterval   = "15s"
+	defaultCheckTimeout    = "5s"
+	defaultCheckDeregister = "120s"
+	defaultCheckTTL        = "30s"
+	defaultCheckHTTPPath   = "/v1.0/healthz"
+)
+
+// HealthCheckConfig is the check registered with an app when no checks are configured. Durations are
+// consul duration strings like "15s".
+type HealthCheckConfig struct {
+	// Type is grpc or http, by default the protocol of the link, or ttl to let the provider report
+	// the health of the link when consul can't reach the listener.
+	Type                           string
+	Interval                       string
+	Timeout                        string
+	DeregisterCriticalServiceAfter string
+	TTL                            string
+	// HTTPPath is the path probed by http checks.
+	HTTPPath string
+}
+
+func (c HealthCheckConfig) withDefaults() HealthCheckConfig {
+	if c.Interval == "" {
+		c.Interval = defaultCheckInterval
+	}
+	if c.Timeout == "" {
+		c.Timeout = defaultCheckTimeout
+	}
+	if c.DeregisterCriticalServiceAfter == "" {
+		c.DeregisterCriticalServiceAfter = defaultCheckDeregister
+	}
+	if c.TTL == "" {
+		c.TTL = defaultCheckTTL
+	}
+	if c.HTTPPath == "" {
+		c.HTTPPath = defaultCheckHTTPPath
+	}
+	return c
+}
+
+func (c HealthCheckConfig) validate() error {
+	switch c.Type {
+	case CheckTypeAuto, CheckTypeGRPC, CheckTypeHTTP, CheckTypeTTL:
+	default:
+		return fmt.Errorf("unknown health check type %q", c.Type)
+	}
+	if _, err := time.ParseDuration(c.TTL); c.Type == CheckTypeTTL && err != nil {
+		return fmt.Errorf("invalid health check ttl: %w", err)
+	}
+	return nil
+}
+
+func checkID(id string) string {
+	return "wasmHealth:" + id
+}
+
+// defaultCheck is the check of a when the template has none.
+func (r *Resolver) defaultCheck(a discovery.App, id string) *consul.AgentServiceCheck {
+	c := r.healthCheck()
+	check := &consul.AgentServiceCheck{
+		Name:                           "Wasm Http Provider Health Status",
+		CheckID:                        checkID(id),
+		DeregisterCriticalServiceAfter: c.DeregisterCriticalServiceAfter,
+	}
+	typ := c.Type
+	if typ == CheckTypeAuto {
+		typ = CheckTypeGRPC
+		if a.Protocol == discovery.ProtocolHTTP {
+			typ = CheckTypeHTTP
+		}
+	}
+	switch typ {
+	case CheckTypeTTL:
+		check.TTL = c.TTL
+		// consul starts ttl checks critical, the provider reports them passing once serving
+		check.Status = consul.HealthCritical
+	case CheckTypeHTTP:
+		check.Interval, check.Timeout = c.Interval, c.Timeout
+		check.HTTP = fmt.Sprintf("http://%s%s", a.Address, c.HTTPPath)
+		check.Method = "GET"
+	default:
+		check.Interval, check.Timeout = c.Interval, c.Timeout
+		check.GRPC = a.Address
+	}
+	return check
+}
+
+func (r *Resolver) healthCheck() HealthCheckConfig {
+	if r.config.HealthCheck == nil {
+		return HealthCheckConfig{}.withDefaults()
+	}
+	return r.config.HealthCheck.withDefaults()
+}
+
+// ttlCheck tells whether the apps are registered with the default check as a ttl check, which the
+// provider has to report. Checks configured in the template are left to consul.
+func (r *Resolver) ttlCheck() bool {
+	if t := r.config.Template; t != nil && (t.Check != nil || len(t.Checks) > 0) {
+		return false
+	}
+	return r.healthCheck().Type == CheckTypeTTL
+}
+
+// SetHealth reports the health of a to its ttl check, it is ignored for other checks.
+func (r *Resolver) SetHealth(a discovery.App, healthy bool) {
+	if !r.ttlCheck() {
+		return
+	}
+	r.l.Lock()
+	r.healthy[a.InstanceID()] = healthy
+	r.l.Unlock()
+	select {
+	case r.reportNow <- struct{}{}:
+	default:
+	}
+}
+
+// reportTTL refreshes the ttl checks of the registered apps often enough for them not to expire.
+func (r *Resolver) reportTTL() {
+	ttl, _ := time.ParseDuration(r.healthCheck().TTL)
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+		case <-r.reportNow:
+		}
+
+		r.l.Lock()
+		status := make(map[string]bool, len(r.apps))
+		for id := range r.apps {
+			status[id] = r.healthy[id]
+		}
+		r.l.Unlock()
+		for id, healthy := range status {
+			s, output := consul.HealthPassing, "serving"
+			if !healthy {
+				s, output = consul.HealthCritical, "not serving"
+			}
+			if err := r.client.Agent().UpdateTTL(checkID(id), output, s); err != nil {
+				r.logger.Warnf("failed to update the ttl check of %s: %s", id, err)
+			}
+		}
+	}
+}
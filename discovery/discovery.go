@@ -6,18 +6,56 @@
 package discovery
 
 import (
+	"strings"
+
 	"github.com/dapr/components-contrib/nameresolution"
 )
 
+const (
+	ProtocolGRPC = "grpc"
+	ProtocolHTTP = "http"
+)
+
 type App struct {
-	AppID   string
-	Version string
-	Address string
-	Host    string
+	AppID   string `json:"app_id"`
+	Version string `json:"version,omitempty"`
+	Address string `json:"address"`
+	Host    string `json:"host"`
+	// Protocol is the protocol served at Address, grpc or http.
+	Protocol string `json:"protocol"`
+}
+
+// InstanceID identifies the registration of the app at its address, so that several providers
+// can serve the same app id.
+func (a App) InstanceID() string {
+	return a.AppID + "-" + strings.NewReplacer(":", "-", "[", "", "]", "").Replace(a.Address)
 }
 
 type Discover interface {
 	nameresolution.Resolver
 	RegisterToDiscovery(a App) (err error)
-	RemoveFromDiscovery(appID string)
+	RemoveFromDiscovery(a App)
+}
+
+// Endpoint is one healthy instance of an app.
+type Endpoint struct {
+	Address string
+	// Weight is the relative share of the calls the instance should get, 0 means 1.
+	Weight int
+	// Locality is the zone the instance runs in, if known.
+	Locality string
+	// Version of the app served by the instance, if known.
+	Version string
+}
+
+// HealthReporter is implemented by resolvers which need the provider to report the health of the
+// registered apps, e.g. for ttl checks.
+type HealthReporter interface {
+	SetHealth(a App, healthy bool)
+}
+
+// EndpointResolver is implemented by resolvers which can return every instance of an app instead of
+// a single address picked for the caller.
+type EndpointResolver interface {
+	ResolveEndpoints(req nameresolution.ResolveRequest) ([]Endpoint, error)
 }
@@ -0,0 +1,83 @@
+package kubernetes
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	nr "github.com/dapr/components-contrib/nameresolution"
+	daprkubernetes "github.com/dapr/components-contrib/nameresolution/kubernetes"
+	"github.com/dapr/kit/config"
+	"github.com/dapr/kit/logger"
+
+	"github.com/taction/http-provider-go/discovery"
+)
+
+const (
+	// defaultPort is the dapr internal gRPC port of sidecars in Kubernetes.
+	defaultPort  = 50002
+	namespaceKey = "namespace"
+	portKey      = "port"
+)
+
+// Resolver resolves app ids to the dapr services of Kubernetes DNS. Registration is done
+// by the Kubernetes services themselves, so it is a no-op here.
+type Resolver struct {
+	nr.Resolver
+	logger    logger.Logger
+	namespace string
+	port      int
+}
+
+func NewResolver(logger logger.Logger) *Resolver {
+	return &Resolver{
+		Resolver:  daprkubernetes.NewResolver(logger),
+		logger:    logger,
+		namespace: "default",
+		port:      defaultPort,
+	}
+}
+
+func (r *Resolver) Init(metadata nr.Metadata) error {
+	if ns := os.Getenv("NAMESPACE"); ns != "" {
+		r.namespace = ns
+	}
+	cfg, err := config.Normalize(metadata.Configuration)
+	if err != nil {
+		return err
+	}
+	// dapr's resolver only reads string values
+	values := map[string]string{}
+	if m, ok := cfg.(map[string]interface{}); ok {
+		for k, v := range m {
+			values[k] = fmt.Sprint(v)
+		}
+	}
+	if ns := values[namespaceKey]; ns != "" {
+		r.namespace = ns
+	}
+	if port := values[portKey]; port != "" {
+		if r.port, err = strconv.Atoi(port); err != nil {
+			return fmt.Errorf("invalid kubernetes dapr port %q: %w", port, err)
+		}
+	}
+	metadata.Configuration = values
+	return r.Resolver.Init(metadata)
+}
+
+func (r *Resolver) ResolveID(req nr.ResolveRequest) (string, error) {
+	if req.Namespace == "" {
+		req.Namespace = r.namespace
+	}
+	if req.Port == 0 {
+		req.Port = r.port
+	}
+	return r.Resolver.ResolveID(req)
+}
+
+func (r *Resolver) RegisterToDiscovery(a discovery.App) error {
+	r.logger.Debugf("app %s is registered by its kubernetes service", a.AppID)
+	return nil
+}
+
+func (r *Resolver) RemoveFromDiscovery(a discovery.App) {}
@@ -0,0 +1,273 @@
+package mdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	nr "github.com/dapr/components-contrib/nameresolution"
+	"github.com/dapr/kit/logger"
+	"github.com/grandcat/zeroconf"
+
+	"github.com/taction/http-provider-go/discovery"
+)
+
+const (
+	// browseTimeout bounds a browse of the network for the instances of an app.
+	browseTimeout = time.Second
+	// cacheTTL is how long the instances of an app are served before they are browsed again in the background.
+	cacheTTL = 30 * time.Second
+	// versionPrefix starts the text entry announcing the version of an app.
+	versionPrefix = "version="
+)
+
+// browseFunc browses the network for the instances of appID, returning after the first one when first is set.
+type browseFunc func(ctx context.Context, appID string, first bool) ([]discovery.Endpoint, error)
+
+type cacheEntry struct {
+	endpoints  []discovery.Endpoint
+	updated    time.Time
+	next       int
+	refreshing bool
+}
+
+// Resolver announces every registered app over mDNS, the same way Dapr's self-hosted default does,
+// and resolves app ids by browsing for their announcements.
+type Resolver struct {
+	logger  logger.Logger
+	browse  browseFunc
+	l       sync.Mutex
+	servers map[string]*zeroconf.Server
+	cache   map[string]*cacheEntry
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+func NewResolver(logger logger.Logger) *Resolver {
+	r := &Resolver{
+		logger:  logger,
+		servers: make(map[string]*zeroconf.Server),
+		cache:   make(map[string]*cacheEntry),
+	}
+	r.browse = r.browseNetwork
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	return r
+}
+
+// Init does not announce anything, apps are announced as links are added.
+func (r *Resolver) Init(metadata nr.Metadata) error {
+	return nil
+}
+
+func (r *Resolver) RegisterToDiscovery(a discovery.App) error {
+	host, port, err := net.SplitHostPort(a.Address)
+	if err != nil {
+		return err
+	}
+	pint, err := strconv.Atoi(port)
+	if err != nil {
+		return err
+	}
+	hostname, _ := os.Hostname()
+	instance := fmt.Sprintf("%s-%s-%d", hostname, a.AppID, pint)
+	text := []string{a.AppID}
+	if a.Version != "" {
+		text = append(text, versionPrefix+a.Version)
+	}
+	var server *zeroconf.Server
+	if host == "" {
+		// without an external address the app is announced at the addresses of every interface
+		server, err = zeroconf.Register(instance, a.AppID, "local.", pint, text, nil)
+	} else {
+		server, err = zeroconf.RegisterProxy(instance, a.AppID, "local.", pint, hostname, []string{host}, text, nil)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to announce %s over mdns: %w", a.AppID, err)
+	}
+
+	r.l.Lock()
+	if old, ok := r.servers[a.InstanceID()]; ok {
+		old.Shutdown()
+	}
+	r.servers[a.InstanceID()] = server
+	r.l.Unlock()
+	r.logger.Infof("mdns entry announced: %s -> %s", a.AppID, a.Address)
+	return nil
+}
+
+func (r *Resolver) RemoveFromDiscovery(a discovery.App) {
+	r.l.Lock()
+	server, ok := r.servers[a.InstanceID()]
+	delete(r.servers, a.InstanceID())
+	r.l.Unlock()
+	if ok {
+		server.Shutdown()
+	}
+}
+
+// ResolveID returns the instances of an app in turn, see ResolveEndpoints for how they are found.
+func (r *Resolver) ResolveID(req nr.ResolveRequest) (string, error) {
+	r.l.Lock()
+	entry, ok := r.cache[req.ID]
+	if ok && len(entry.endpoints) > 0 {
+		address := entry.endpoints[entry.next%len(entry.endpoints)].Address
+		entry.next++
+		if time.Since(entry.updated) >= cacheTTL {
+			r.refreshLocked(req.ID, entry)
+		}
+		r.l.Unlock()
+		return address, nil
+	}
+	r.l.Unlock()
+
+	endpoints, err := r.browseFirst(req.ID)
+	if err != nil {
+		return "", err
+	}
+	return endpoints[0].Address, nil
+}
+
+// ResolveEndpoints returns every instance found for an app with the version it announces. The first
+// resolution of an app browses the network until an instance answers, then every instance is collected
+// in the background. Entries older than cacheTTL are served while they are browsed again in the background.
+func (r *Resolver) ResolveEndpoints(req nr.ResolveRequest) ([]discovery.Endpoint, error) {
+	r.l.Lock()
+	entry, ok := r.cache[req.ID]
+	if ok && len(entry.endpoints) > 0 {
+		endpoints := append([]discovery.Endpoint(nil), entry.endpoints...)
+		if time.Since(entry.updated) >= cacheTTL {
+			r.refreshLocked(req.ID, entry)
+		}
+		r.l.Unlock()
+		return endpoints, nil
+	}
+	r.l.Unlock()
+	return r.browseFirst(req.ID)
+}
+
+// browseFirst browses the network until an instance of appID answers, caches it and collects the
+// other instances in the background.
+func (r *Resolver) browseFirst(appID string) ([]discovery.Endpoint, error) {
+	ctx, cancel := context.WithTimeout(r.ctx, browseTimeout)
+	defer cancel()
+	endpoints, err := r.browse(ctx, appID, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("couldn't find service: %s", appID)
+	}
+
+	r.l.Lock()
+	defer r.l.Unlock()
+	entry, ok := r.cache[appID]
+	if !ok {
+		entry = &cacheEntry{endpoints: endpoints, updated: time.Now()}
+		r.cache[appID] = entry
+	}
+	r.refreshLocked(appID, entry)
+	return endpoints, nil
+}
+
+// refreshLocked browses for every instance of appID in the background, unless a browse is running.
+// It must be called with l held.
+func (r *Resolver) refreshLocked(appID string, entry *cacheEntry) {
+	if entry.refreshing {
+		return
+	}
+	entry.refreshing = true
+	go func() {
+		ctx, cancel := context.WithTimeout(r.ctx, browseTimeout)
+		defer cancel()
+		endpoints, err := r.browse(ctx, appID, false)
+		r.l.Lock()
+		defer r.l.Unlock()
+		entry.refreshing = false
+		if err != nil {
+			r.logger.Warnf("failed to refresh the mdns instances of %s: %s", appID, err)
+			return
+		}
+		if len(endpoints) > 0 {
+			entry.endpoints, entry.updated = endpoints, time.Now()
+		}
+	}()
+}
+
+// browseNetwork collects the instances announced for appID until ctx is done.
+func (r *Resolver) browseNetwork(ctx context.Context, appID string, first bool) ([]discovery.Endpoint, error) {
+	resolver, err := zeroconf.NewResolver(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize mdns resolver: %w", err)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	entries := make(chan *zeroconf.ServiceEntry)
+	if err := resolver.Browse(ctx, appID, "local.", entries); err != nil {
+		return nil, fmt.Errorf("failed to browse for %s: %w", appID, err)
+	}
+
+	var endpoints []discovery.Endpoint
+	seen := make(map[string]bool)
+	for {
+		select {
+		case entry := <-entries:
+			if entry == nil || !announces(entry, appID) {
+				continue
+			}
+			version := announcedVersion(entry)
+			for _, ip := range append(entry.AddrIPv4, entry.AddrIPv6...) {
+				address := net.JoinHostPort(ip.String(), strconv.Itoa(entry.Port))
+				if !seen[address] {
+					seen[address] = true
+					endpoints = append(endpoints, discovery.Endpoint{Address: address, Weight: 1, Version: version})
+				}
+			}
+			if first && len(endpoints) > 0 {
+				return endpoints, nil
+			}
+		case <-ctx.Done():
+			if errors.Is(r.ctx.Err(), context.Canceled) {
+				return nil, errors.New("mdns resolver closed")
+			}
+			return endpoints, nil
+		}
+	}
+}
+
+func announces(entry *zeroconf.ServiceEntry, appID string) bool {
+	for _, text := range entry.Text {
+		if text == appID {
+			return true
+		}
+	}
+	return false
+}
+
+func announcedVersion(entry *zeroconf.ServiceEntry) string {
+	for _, text := range entry.Text {
+		if strings.HasPrefix(text, versionPrefix) {
+			return strings.TrimPrefix(text, versionPrefix)
+		}
+	}
+	return ""
+}
+
+// Close stops the announcements and the background browses.
+func (r *Resolver) Close() error {
+	r.cancel()
+	r.l.Lock()
+	servers := r.servers
+	r.servers = make(map[string]*zeroconf.Server)
+	r.cache = make(map[string]*cacheEntry)
+	r.l.Unlock()
+	for _, server := range servers {
+		server.Shutdown()
+	}
+	return nil
+}
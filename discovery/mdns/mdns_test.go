@@ -0,0 +1,123 @@
+package mdns
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	nr "github.com/dapr/components-contrib/nameresolution"
+	"github.com/dapr/kit/logger"
+	"github.com/grandcat/zeroconf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/taction/http-provider-go/discovery"
+)
+
+func newTestResolver(browse browseFunc) *Resolver {
+	r := NewResolver(logger.NewLogger("test"))
+	r.browse = browse
+	return r
+}
+
+// resolving many apps never waits on the background refreshes.
+func TestResolveIDManyApps(t *testing.T) {
+	var browses atomic.Int32
+	r := newTestResolver(func(ctx context.Context, appID string, first bool) ([]discovery.Endpoint, error) {
+		browses.Add(1)
+		return []discovery.Endpoint{{Address: "10.0.0.1:50002", Weight: 1}}, nil
+	})
+	defer r.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			_, err := r.ResolveID(nr.ResolveRequest{ID: fmt.Sprintf("app-%d", i)})
+			assert.NoError(t, err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("resolutions blocked")
+	}
+	assert.Eventually(t, func() bool { return browses.Load() == 200 }, time.Second, 10*time.Millisecond)
+}
+
+func TestResolveIDRefresh(t *testing.T) {
+	r := newTestResolver(func(ctx context.Context, appID string, first bool) ([]discovery.Endpoint, error) {
+		if first {
+			return []discovery.Endpoint{{Address: "10.0.0.1:50002", Weight: 1}}, nil
+		}
+		return []discovery.Endpoint{{Address: "10.0.0.1:50002", Weight: 1}, {Address: "10.0.0.2:50002", Weight: 1}}, nil
+	})
+	defer r.Close()
+
+	addr, err := r.ResolveID(nr.ResolveRequest{ID: "a"})
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1:50002", addr)
+	// the background browse finds every instance, which are then used in turn
+	assert.Eventually(t, func() bool {
+		r.l.Lock()
+		defer r.l.Unlock()
+		return len(r.cache["a"].endpoints) == 2 && !r.cache["a"].refreshing
+	}, time.Second, 10*time.Millisecond)
+	first, _ := r.ResolveID(nr.ResolveRequest{ID: "a"})
+	second, _ := r.ResolveID(nr.ResolveRequest{ID: "a"})
+	assert.ElementsMatch(t, []string{"10.0.0.1:50002", "10.0.0.2:50002"}, []string{first, second})
+
+	// stale entries are served right away while they are refreshed
+	r.l.Lock()
+	r.cache["a"].updated = time.Now().Add(-2 * cacheTTL)
+	r.l.Unlock()
+	_, err = r.ResolveID(nr.ResolveRequest{ID: "a"})
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		r.l.Lock()
+		defer r.l.Unlock()
+		return time.Since(r.cache["a"].updated) < cacheTTL
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestResolveEndpoints(t *testing.T) {
+	r := newTestResolver(func(ctx context.Context, appID string, first bool) ([]discovery.Endpoint, error) {
+		v1 := discovery.Endpoint{Address: "10.0.0.1:50002", Weight: 1, Version: "v1"}
+		if first {
+			return []discovery.Endpoint{v1}, nil
+		}
+		return []discovery.Endpoint{v1, {Address: "10.0.0.2:50002", Weight: 1, Version: "v2"}}, nil
+	})
+	defer r.Close()
+
+	endpoints, err := r.ResolveEndpoints(nr.ResolveRequest{ID: "a"})
+	require.NoError(t, err)
+	assert.Equal(t, []discovery.Endpoint{{Address: "10.0.0.1:50002", Weight: 1, Version: "v1"}}, endpoints)
+	// once the background browse is done every instance is returned with its version
+	assert.Eventually(t, func() bool {
+		endpoints, err = r.ResolveEndpoints(nr.ResolveRequest{ID: "a"})
+		return err == nil && len(endpoints) == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "v2", endpoints[1].Version)
+}
+
+func TestAnnouncedVersion(t *testing.T) {
+	assert.Equal(t, "v1", announcedVersion(&zeroconf.ServiceEntry{Text: []string{"a", "version=v1"}}))
+	assert.Equal(t, "", announcedVersion(&zeroconf.ServiceEntry{Text: []string{"a"}}))
+}
+
+func TestResolveIDNotFound(t *testing.T) {
+	r := newTestResolver(func(ctx context.Context, appID string, first bool) ([]discovery.Endpoint, error) {
+		return nil, nil
+	})
+	_, err := r.ResolveEndpoints(nr.ResolveRequest{ID: "a"})
+	assert.EqualError(t, err, "couldn't find service: a")
+	_, err = r.ResolveID(nr.ResolveRequest{ID: "a"})
+	assert.EqualError(t, err, "couldn't find service: a")
+
+	// Close stops the browses of the resolver
+	require.NoError(t, r.Close())
+	assert.Error(t, r.ctx.Err())
+}
@@ -0,0 +1,47 @@
+package discovery
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/dapr/kit/logger"
+)
+
+// Factory creates a resolver which still needs to be initialised.
+type Factory func(logger logger.Logger) Discover
+
+var (
+	registryLock sync.RWMutex
+	registry     = map[string]Factory{}
+)
+
+// Register makes a resolver backend available under name.
+func Register(name string, factory Factory) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	registry[name] = factory
+}
+
+// Create returns a new resolver of the backend registered as name.
+func Create(name string, logger logger.Logger) (Discover, error) {
+	registryLock.RLock()
+	factory, ok := registry[name]
+	registryLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown name resolution component %q, available: %v", name, Names())
+	}
+	return factory(logger), nil
+}
+
+// Names lists the registered backends.
+func Names() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,159 @@
+package standalone
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+const (
+	// daprPortMetaKey is the meta of the dapr grpc port of the default consul name resolution.
+	daprPortMetaKey = "DAPR_PORT"
+	maxWait         = 10 * time.Minute
+	defaultWait     = 5 * time.Minute
+)
+
+// catalog serves what the consul name resolution of daprd uses: the agent self check, service
+// (de)registration and the healthy instances of a service, with blocking queries.
+func (r *Resolver) catalog() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/agent/self", r.agentSelf)
+	mux.HandleFunc("/v1/agent/service/register", r.agentRegister)
+	mux.HandleFunc("/v1/agent/service/deregister/", r.agentDeregister)
+	mux.HandleFunc("/v1/health/service/", r.healthService)
+	return mux
+}
+
+func (r *Resolver) agentSelf(w http.ResponseWriter, req *http.Request) {
+	hostname, _ := os.Hostname()
+	writeJSON(w, map[string]map[string]interface{}{
+		"Config": {"Datacenter": "dc1", "NodeName": hostname, "Server": false},
+		"Member": {"Name": hostname},
+	})
+}
+
+func (r *Resolver) agentRegister(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPut {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var registration consul.AgentServiceRegistration
+	if err := json.NewDecoder(req.Body).Decode(&registration); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	port := registration.Meta[daprPortMetaKey]
+	if registration.Name == "" || port == "" {
+		http.Error(w, "service name and "+daprPortMetaKey+" meta are required", http.StatusBadRequest)
+		return
+	}
+	address := registration.Address
+	if address == "" {
+		address, _, _ = net.SplitHostPort(req.RemoteAddr)
+	}
+	id := registration.ID
+	if id == "" {
+		id = registration.Name
+	}
+	r.l.Lock()
+	r.remote[id] = Instance{ID: id, AppID: registration.Name, Address: net.JoinHostPort(address, port), Version: registration.Meta["version"]}
+	r.notify()
+	r.l.Unlock()
+	r.logger.Infof("service %s registered through the catalog api", id)
+}
+
+func (r *Resolver) agentDeregister(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPut {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(req.URL.Path, "/v1/agent/service/deregister/")
+	r.l.Lock()
+	delete(r.remote, id)
+	r.notify()
+	r.l.Unlock()
+}
+
+func (r *Resolver) healthService(w http.ResponseWriter, req *http.Request) {
+	name := strings.TrimPrefix(req.URL.Path, "/v1/health/service/")
+	query := req.URL.Query()
+	if index, err := strconv.ParseUint(query.Get("index"), 10, 64); err == nil && index > 0 {
+		wait := defaultWait
+		if d, err := time.ParseDuration(query.Get("wait")); err == nil && d > 0 {
+			wait = d
+		}
+		if wait > maxWait {
+			wait = maxWait
+		}
+		r.waitChange(req, index, wait)
+	}
+
+	instances := r.instances(name)
+	entries := make([]*consul.ServiceEntry, 0, len(instances))
+	hostname, _ := os.Hostname()
+	for _, i := range instances {
+		host, port, err := net.SplitHostPort(i.Address)
+		if err != nil {
+			continue
+		}
+		pint, _ := strconv.Atoi(port)
+		meta := map[string]string{daprPortMetaKey: port}
+		if i.Version != "" {
+			meta["version"] = i.Version
+		}
+		entries = append(entries, &consul.ServiceEntry{
+			Node: &consul.Node{Node: hostname, Address: host, Datacenter: "dc1"},
+			Service: &consul.AgentService{
+				ID:      i.ID,
+				Service: i.AppID,
+				Address: host,
+				Port:    pint,
+				Meta:    meta,
+				Weights: consul.AgentWeights{Passing: 1, Warning: 1},
+			},
+			Checks: consul.HealthChecks{},
+		})
+	}
+
+	r.l.RLock()
+	index := r.index
+	r.l.RUnlock()
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(index, 10))
+	w.Header().Set("X-Consul-LastContact", "0")
+	w.Header().Set("X-Consul-KnownLeader", "true")
+	writeJSON(w, entries)
+}
+
+// waitChange blocks until the registry index moves past index, the wait time or the request is done.
+func (r *Resolver) waitChange(req *http.Request, index uint64, wait time.Duration) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		r.l.RLock()
+		current, changed := r.index, r.changed
+		r.l.RUnlock()
+		if current != index {
+			return
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return
+		case <-req.Context().Done():
+			return
+		case <-r.done:
+			return
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,336 @@
+package standalone
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	nr "github.com/dapr/components-contrib/nameresolution"
+	"github.com/dapr/kit/config"
+	"github.com/dapr/kit/logger"
+
+	"github.com/taction/http-provider-go/discovery"
+)
+
+// refreshInterval is how often our instances are written to the shared file again, in case another
+// provider overwrote them.
+const refreshInterval = 10 * time.Second
+
+// Config of the standalone registry.
+type Config struct {
+	// Apps are static peers, app ids to addresses.
+	Apps map[string][]string `json:"apps"`
+	// File is shared by the providers of a machine, each keeps its own instances in it.
+	File string `json:"file"`
+	// Owner identifies the instances of this provider in File, defaults to hostname-pid.
+	Owner string `json:"owner"`
+	// CatalogAddress serves a subset of the consul http api on this address, for daprd sidecars
+	// configured with consul name resolution.
+	CatalogAddress string `json:"catalogAddress"`
+}
+
+// Instance is an app instance known by the registry.
+type Instance struct {
+	ID      string `json:"id"`
+	AppID   string `json:"app_id"`
+	Address string `json:"address"`
+	Version string `json:"version,omitempty"`
+	Owner   string `json:"owner,omitempty"`
+}
+
+// Resolver keeps the registrations in memory, shares them through a file and resolves them along
+// with static peers and the sidecars registered through the catalog api.
+type Resolver struct {
+	logger logger.Logger
+	config Config
+
+	l        sync.RWMutex
+	local    map[string]Instance
+	remote   map[string]Instance // registered through the catalog api
+	file     []Instance
+	fileInfo os.FileInfo
+	index    uint64
+	changed  chan struct{} // closed and replaced on every change
+
+	fileLock sync.Mutex
+	server   *http.Server
+	done     chan struct{}
+	closed   sync.Once
+}
+
+func NewResolver(logger logger.Logger) *Resolver {
+	return &Resolver{
+		logger:  logger,
+		local:   make(map[string]Instance),
+		remote:  make(map[string]Instance),
+		index:   1,
+		changed: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+func (r *Resolver) Init(metadata nr.Metadata) error {
+	cfg, err := parseConfig(metadata.Configuration)
+	if err != nil {
+		return err
+	}
+	if cfg.Owner == "" {
+		hostname, _ := os.Hostname()
+		cfg.Owner = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+	r.config = cfg
+
+	if cfg.File != "" {
+		if err := r.loadFile(); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		go r.refresh()
+	}
+	if cfg.CatalogAddress != "" {
+		ln, err := net.Listen("tcp", cfg.CatalogAddress)
+		if err != nil {
+			return fmt.Errorf("failed to listen for the catalog api: %w", err)
+		}
+		r.server = &http.Server{Handler: r.catalog(), ReadHeaderTimeout: 5 * time.Second}
+		go func() {
+			if err := r.server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				r.logger.Errorf("catalog api stopped: %s", err)
+			}
+		}()
+		r.logger.Infof("serving the consul catalog api on %s", ln.Addr())
+	}
+	return nil
+}
+
+func parseConfig(rawConfig interface{}) (Config, error) {
+	var result Config
+	rawConfig, err := config.Normalize(rawConfig)
+	if err != nil || rawConfig == nil {
+		return result, err
+	}
+	data, err := json.Marshal(rawConfig)
+	if err != nil {
+		return result, fmt.Errorf("error serializing to json: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&result); err != nil {
+		return result, fmt.Errorf("error deserializing standalone registry config: %w", err)
+	}
+	return result, nil
+}
+
+func (r *Resolver) ResolveID(req nr.ResolveRequest) (string, error) {
+	instances := r.instances(req.ID)
+	if len(instances) == 0 {
+		return "", fmt.Errorf("no address found with AppID:%s", req.ID)
+	}
+	rndbig, _ := rand.Int(rand.Reader, big.NewInt(int64(len(instances))))
+	return instances[rndbig.Int64()].Address, nil
+}
+
+func (r *Resolver) ResolveEndpoints(req nr.ResolveRequest) ([]discovery.Endpoint, error) {
+	instances := r.instances(req.ID)
+	if len(instances) == 0 {
+		return nil, fmt.Errorf("no address found with AppID:%s", req.ID)
+	}
+	endpoints := make([]discovery.Endpoint, 0, len(instances))
+	for _, i := range instances {
+		endpoints = append(endpoints, discovery.Endpoint{Address: i.Address, Weight: 1, Version: i.Version})
+	}
+	return endpoints, nil
+}
+
+// instances returns every known instance of appID, or of every app when appID is empty, once per address.
+func (r *Resolver) instances(appID string) []Instance {
+	if r.config.File != "" {
+		if err := r.loadFile(); err != nil && !errors.Is(err, os.ErrNotExist) {
+			r.logger.Warnf("failed to reload the registry file, using the previous content: %s", err)
+		}
+	}
+	r.l.RLock()
+	defer r.l.RUnlock()
+	var instances []Instance
+	seen := map[string]bool{}
+	add := func(i Instance) {
+		if (appID == "" || i.AppID == appID) && !seen[i.AppID+"/"+i.Address] {
+			seen[i.AppID+"/"+i.Address] = true
+			instances = append(instances, i)
+		}
+	}
+	for _, i := range r.local {
+		add(i)
+	}
+	for _, i := range r.remote {
+		add(i)
+	}
+	for _, i := range r.file {
+		add(i)
+	}
+	for id, addresses := range r.config.Apps {
+		for _, address := range addresses {
+			add(Instance{ID: id + "-" + address, AppID: id, Address: address})
+		}
+	}
+	return instances
+}
+
+func (r *Resolver) RegisterToDiscovery(a discovery.App) error {
+	r.l.Lock()
+	r.local[a.InstanceID()] = Instance{ID: a.InstanceID(), AppID: a.AppID, Address: a.Address, Version: a.Version, Owner: r.config.Owner}
+	r.notify()
+	r.l.Unlock()
+	return r.writeFile()
+}
+
+func (r *Resolver) RemoveFromDiscovery(a discovery.App) {
+	r.l.Lock()
+	delete(r.local, a.InstanceID())
+	r.notify()
+	r.l.Unlock()
+	if err := r.writeFile(); err != nil {
+		r.logger.Warnf("failed to remove %s from the registry file: %s", a.InstanceID(), err)
+	}
+}
+
+// Close stops the catalog api and removes our instances from the shared file.
+func (r *Resolver) Close() error {
+	var err error
+	r.closed.Do(func() {
+		close(r.done)
+		if r.server != nil {
+			err = r.server.Close()
+		}
+		r.l.Lock()
+		r.local = make(map[string]Instance)
+		r.l.Unlock()
+		if werr := r.writeFile(); werr != nil {
+			err = werr
+		}
+	})
+	return err
+}
+
+// notify wakes up the blocking queries of the catalog api, needs to be called with the lock held.
+func (r *Resolver) notify() {
+	r.index++
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+func (r *Resolver) refresh() {
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+		}
+		if err := r.writeFile(); err != nil {
+			r.logger.Warnf("failed to refresh the registry file: %s", err)
+		}
+		// wakes up the blocking queries when other providers changed the file
+		if err := r.loadFile(); err != nil {
+			r.logger.Warnf("failed to reload the registry file: %s", err)
+		}
+	}
+}
+
+// loadFile reads the instances of the other providers from the file if it changed since the last read.
+func (r *Resolver) loadFile() error {
+	info, err := os.Stat(r.config.File)
+	if err != nil {
+		return err
+	}
+	// the file is replaced on every write, a new inode is a change even within the mtime granularity
+	r.l.RLock()
+	unchanged := r.fileInfo != nil && os.SameFile(info, r.fileInfo) && info.ModTime().Equal(r.fileInfo.ModTime())
+	r.l.RUnlock()
+	if unchanged {
+		return nil
+	}
+	instances, err := readInstances(r.config.File)
+	if err != nil {
+		return err
+	}
+	others := make([]Instance, 0, len(instances))
+	for _, i := range instances {
+		if i.Owner != r.config.Owner {
+			others = append(others, i)
+		}
+	}
+	r.l.Lock()
+	r.file, r.fileInfo = others, info
+	r.notify()
+	r.l.Unlock()
+	return nil
+}
+
+func readInstances(file string) ([]Instance, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var instances []Instance
+	if len(bytes.TrimSpace(data)) == 0 {
+		return instances, nil
+	}
+	if err := json.Unmarshal(data, &instances); err != nil {
+		return nil, fmt.Errorf("invalid registry file %s: %w", file, err)
+	}
+	return instances, nil
+}
+
+// writeFile replaces our instances in the file, the file is replaced atomically so readers never see
+// a partial write.
+func (r *Resolver) writeFile() error {
+	if r.config.File == "" {
+		return nil
+	}
+	r.fileLock.Lock()
+	defer r.fileLock.Unlock()
+
+	instances, err := readInstances(r.config.File)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	merged := make([]Instance, 0, len(instances))
+	for _, i := range instances {
+		if i.Owner != r.config.Owner {
+			merged = append(merged, i)
+		}
+	}
+	r.l.RLock()
+	for _, i := range r.local {
+		merged = append(merged, i)
+	}
+	r.l.RUnlock()
+
+	data, err := json.MarshalIndent(merged, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(r.config.File), filepath.Base(r.config.File)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), r.config.File)
+}
@@ -0,0 +1,124 @@
+package standalone
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dapr/components-contrib/metadata"
+	nr "github.com/dapr/components-contrib/nameresolution"
+	"github.com/dapr/kit/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/taction/http-provider-go/discovery"
+	"github.com/taction/http-provider-go/discovery/consul"
+)
+
+func TestSharedFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "registry.json")
+	newRegistry := func(owner string) *Resolver {
+		r := NewResolver(logger.NewLogger("test"))
+		require.NoError(t, r.Init(nr.Metadata{Configuration: map[string]interface{}{
+			"file":  file,
+			"owner": owner,
+			"apps":  map[string]interface{}{"static": []interface{}{"10.0.0.1:50002"}},
+		}}))
+		return r
+	}
+	first, second := newRegistry("first"), newRegistry("second")
+	defer second.Close()
+
+	one := discovery.App{AppID: "app", Address: "127.0.0.1:8001", Version: "v1"}
+	two := discovery.App{AppID: "app", Address: "127.0.0.1:8002"}
+	require.NoError(t, first.RegisterToDiscovery(one))
+	require.NoError(t, second.RegisterToDiscovery(two))
+
+	endpoints, err := first.ResolveEndpoints(nr.ResolveRequest{ID: "app"})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []discovery.Endpoint{
+		{Address: "127.0.0.1:8001", Weight: 1, Version: "v1"},
+		{Address: "127.0.0.1:8002", Weight: 1},
+	}, endpoints)
+	addr, err := second.ResolveID(nr.ResolveRequest{ID: "static"})
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1:50002", addr)
+
+	// closing removes the instances of the provider from the file
+	require.NoError(t, first.Close())
+	endpoints, err = second.ResolveEndpoints(nr.ResolveRequest{ID: "app"})
+	require.NoError(t, err)
+	assert.Equal(t, []discovery.Endpoint{{Address: "127.0.0.1:8002", Weight: 1}}, endpoints)
+
+	second.RemoveFromDiscovery(two)
+	_, err = second.ResolveID(nr.ResolveRequest{ID: "app"})
+	assert.Error(t, err)
+}
+
+func TestCatalogAPI(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := ln.Addr().String()
+	ln.Close()
+
+	registry := NewResolver(logger.NewLogger("test"))
+	require.NoError(t, registry.Init(nr.Metadata{Configuration: map[string]interface{}{"catalogAddress": address}}))
+	defer registry.Close()
+	require.NoError(t, registry.RegisterToDiscovery(discovery.App{AppID: "actor", Address: "127.0.0.1:8888"}))
+
+	// a daprd sidecar with consul name resolution resolves our actors
+	sidecar := consul.NewResolver(logger.NewLogger("test"))
+	require.NoError(t, sidecar.Init(nr.Metadata{
+		Base: metadata.Base{Properties: map[string]string{
+			nr.AppID:        "checkout",
+			nr.AppPort:      "3000",
+			nr.HostAddress:  "127.0.0.1",
+			nr.DaprHTTPPort: "3500",
+			nr.DaprPort:     "50001",
+		}},
+		Configuration: map[string]interface{}{
+			"client":       map[string]interface{}{"address": address},
+			"selfRegister": true,
+		},
+	}))
+	defer sidecar.Close()
+	addr, err := sidecar.ResolveID(nr.ResolveRequest{ID: "actor"})
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:8888", addr)
+
+	// and registers itself so that our actors can call it
+	addr, err = registry.ResolveID(nr.ResolveRequest{ID: "checkout"})
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:50001", addr)
+}
+
+func TestCatalogBlockingQuery(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := ln.Addr().String()
+	ln.Close()
+
+	registry := NewResolver(logger.NewLogger("test"))
+	require.NoError(t, registry.Init(nr.Metadata{Configuration: map[string]interface{}{"catalogAddress": address}}))
+	defer registry.Close()
+	require.NoError(t, registry.RegisterToDiscovery(discovery.App{AppID: "actor", Address: "127.0.0.1:8888"}))
+
+	// the endpoint cache of the consul resolver watches with blocking queries
+	r := consul.NewResolver(logger.NewLogger("test"))
+	require.NoError(t, r.Init(nr.Metadata{Configuration: map[string]interface{}{
+		"client":      map[string]interface{}{"address": address},
+		"cache":       map[string]interface{}{"enabled": true},
+		"antiEntropy": map[string]interface{}{"disabled": true},
+	}}))
+	defer r.Close()
+	addr, err := r.ResolveID(nr.ResolveRequest{ID: "actor"})
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:8888", addr)
+
+	require.NoError(t, registry.RegisterToDiscovery(discovery.App{AppID: "actor", Address: "127.0.0.1:9999"}))
+	assert.Eventually(t, func() bool {
+		endpoints, err := r.ResolveEndpoints(nr.ResolveRequest{ID: "actor"})
+		return err == nil && len(endpoints) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+}
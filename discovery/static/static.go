@@ -0,0 +1,165 @@
+package static
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	nr "github.com/dapr/components-contrib/nameresolution"
+	"github.com/dapr/kit/config"
+	"github.com/dapr/kit/logger"
+
+	"github.com/taction/http-provider-go/discovery"
+)
+
+// Config maps app ids to addresses, inline or from a json file of the same shape as Apps.
+type Config struct {
+	Apps map[string][]string `json:"apps"`
+	File string              `json:"file"`
+}
+
+// Resolver resolves app ids from a fixed table. Apps registered by the provider are resolvable as well.
+type Resolver struct {
+	logger logger.Logger
+	config Config
+
+	l       sync.RWMutex
+	file    map[string][]string
+	modTime time.Time
+	apps    map[string]discovery.App
+}
+
+func NewResolver(logger logger.Logger) *Resolver {
+	return &Resolver{
+		logger: logger,
+		apps:   make(map[string]discovery.App),
+	}
+}
+
+func (r *Resolver) Init(metadata nr.Metadata) error {
+	cfg, err := parseConfig(metadata.Configuration)
+	if err != nil {
+		return err
+	}
+	r.config = cfg
+	if cfg.File != "" {
+		return r.loadFile()
+	}
+	return nil
+}
+
+func parseConfig(rawConfig interface{}) (Config, error) {
+	var result Config
+	rawConfig, err := config.Normalize(rawConfig)
+	if err != nil || rawConfig == nil {
+		return result, err
+	}
+	data, err := json.Marshal(rawConfig)
+	if err != nil {
+		return result, fmt.Errorf("error serializing to json: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&result); err != nil {
+		return result, fmt.Errorf("error deserializing static resolver config: %w", err)
+	}
+	return result, nil
+}
+
+// loadFile reads the file again if it changed since the last read.
+func (r *Resolver) loadFile() error {
+	info, err := os.Stat(r.config.File)
+	if err != nil {
+		return err
+	}
+	r.l.RLock()
+	unchanged := info.ModTime().Equal(r.modTime)
+	r.l.RUnlock()
+	if unchanged {
+		return nil
+	}
+	data, err := os.ReadFile(r.config.File)
+	if err != nil {
+		return err
+	}
+	apps := map[string][]string{}
+	if err := json.Unmarshal(data, &apps); err != nil {
+		return fmt.Errorf("invalid static resolver file %s: %w", r.config.File, err)
+	}
+	r.l.Lock()
+	r.file, r.modTime = apps, info.ModTime()
+	r.l.Unlock()
+	return nil
+}
+
+func (r *Resolver) ResolveID(req nr.ResolveRequest) (string, error) {
+	addresses, err := r.addresses(req.ID)
+	if err != nil {
+		return "", err
+	}
+	rndbig, _ := rand.Int(rand.Reader, big.NewInt(int64(len(addresses))))
+	return addresses[rndbig.Int64()], nil
+}
+
+// ResolveEndpoints returns every address of the app id with the same weight, only the registered apps
+// have a version.
+func (r *Resolver) ResolveEndpoints(req nr.ResolveRequest) ([]discovery.Endpoint, error) {
+	addresses, err := r.addresses(req.ID)
+	if err != nil {
+		return nil, err
+	}
+	r.l.RLock()
+	versions := map[string]string{}
+	for _, a := range r.apps {
+		if a.AppID == req.ID {
+			versions[a.Address] = a.Version
+		}
+	}
+	r.l.RUnlock()
+	endpoints := make([]discovery.Endpoint, 0, len(addresses))
+	for _, address := range addresses {
+		endpoints = append(endpoints, discovery.Endpoint{Address: address, Weight: 1, Version: versions[address]})
+	}
+	return endpoints, nil
+}
+
+func (r *Resolver) addresses(appID string) ([]string, error) {
+	if r.config.File != "" {
+		if err := r.loadFile(); err != nil {
+			r.logger.Warnf("failed to reload static resolver file, using the previous content: %s", err)
+		}
+	}
+	r.l.RLock()
+	var addresses []string
+	for _, a := range r.apps {
+		if a.AppID == appID {
+			addresses = append(addresses, a.Address)
+		}
+	}
+	addresses = append(addresses, r.config.Apps[appID]...)
+	addresses = append(addresses, r.file[appID]...)
+	r.l.RUnlock()
+
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("no address found with AppID:%s", appID)
+	}
+	return addresses, nil
+}
+
+func (r *Resolver) RegisterToDiscovery(a discovery.App) error {
+	r.l.Lock()
+	r.apps[a.InstanceID()] = a
+	r.l.Unlock()
+	return nil
+}
+
+func (r *Resolver) RemoveFromDiscovery(a discovery.App) {
+	r.l.Lock()
+	delete(r.apps, a.InstanceID())
+	r.l.Unlock()
+}
@@ -0,0 +1,51 @@
+package static
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	nr "github.com/dapr/components-contrib/nameresolution"
+	"github.com/dapr/kit/logger"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/taction/http-provider-go/discovery"
+)
+
+func TestResolveID(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "apps.json")
+	assert.NoError(t, os.WriteFile(file, []byte(`{"from-file": ["10.0.0.2:50002"]}`), 0o600))
+
+	r := NewResolver(logger.NewLogger("test"))
+	err := r.Init(nr.Metadata{Configuration: map[string]interface{}{
+		"apps": map[string]interface{}{"inline": []interface{}{"10.0.0.1:50002"}},
+		"file": file,
+	}})
+	assert.NoError(t, err)
+
+	addr, err := r.ResolveID(nr.ResolveRequest{ID: "inline"})
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.1:50002", addr)
+
+	addr, err = r.ResolveID(nr.ResolveRequest{ID: "from-file"})
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.2:50002", addr)
+
+	_, err = r.ResolveID(nr.ResolveRequest{ID: "local"})
+	assert.Error(t, err)
+	local := discovery.App{AppID: "local", Address: "127.0.0.1:8888"}
+	assert.NoError(t, r.RegisterToDiscovery(local))
+	addr, err = r.ResolveID(nr.ResolveRequest{ID: "local"})
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:8888", addr)
+
+	r.RemoveFromDiscovery(local)
+	_, err = r.ResolveID(nr.ResolveRequest{ID: "local"})
+	assert.Error(t, err)
+}
+
+func TestInitUnknownField(t *testing.T) {
+	r := NewResolver(logger.NewLogger("test"))
+	err := r.Init(nr.Metadata{Configuration: map[string]interface{}{"addresses": "x"}})
+	assert.Error(t, err)
+}
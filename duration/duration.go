@@ -0,0 +1,45 @@
+package duration
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Duration is a time.Duration written as a string like "5s" in json. Numbers are read as nanoseconds.
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch value := v.(type) {
+	case float64:
+		d.Duration = time.Duration(value)
+	case string:
+		var err error
+		d.Duration, err = Parse(value)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid duration %s, use a string like \"5s\"", string(b))
+	}
+	return nil
+}
+
+// Parse parses a duration like "5s" or "1m30s".
+func Parse(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q, use a value like \"5s\" or \"1m30s\"", s)
+	}
+	return d, nil
+}
@@ -0,0 +1,26 @@
+package duration
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDuration(t *testing.T) {
+	var d Duration
+	require.NoError(t, json.Unmarshal([]byte(`"1m30s"`), &d))
+	assert.Equal(t, 90*time.Second, d.Duration)
+
+	require.NoError(t, json.Unmarshal([]byte(`5000000000`), &d))
+	assert.Equal(t, 5*time.Second, d.Duration)
+
+	assert.EqualError(t, json.Unmarshal([]byte(`"5"`), &d), `invalid duration "5", use a value like "5s" or "1m30s"`)
+	assert.Error(t, json.Unmarshal([]byte(`true`), &d))
+
+	b, err := json.Marshal(Duration{time.Minute})
+	require.NoError(t, err)
+	assert.Equal(t, `"1m0s"`, string(b))
+}
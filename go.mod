@@ -8,7 +8,8 @@ require (
 	github.com/dapr/dapr v1.9.4
 	github.com/dapr/kit v0.0.3-0.20220930182601-272e358ba6a7
 	github.com/google/uuid v1.3.0
-	github.com/hashicorp/consul/api v1.11.0
+	github.com/grandcat/zeroconf v0.0.0-20190424104450-85eadb44205c
+	github.com/hashicorp/consul/api v1.12.0
 	github.com/jordan-rash/wasmcloud-provider v0.0.0-20220901133242-6e3d105801c3
 	github.com/nats-io/nats.go v1.16.0
 	github.com/sirupsen/logrus v1.9.0
@@ -18,11 +19,13 @@ require (
 	github.com/wasmcloud/interfaces/httpserver/tinygo v0.0.0-20221004165741-b9aa48b3b4c2
 	github.com/wasmcloud/tinygo-msgpack v0.1.4
 	google.golang.org/grpc v1.48.0
+	google.golang.org/protobuf v1.28.1
 )
 
 require (
 	github.com/andybalholm/brotli v1.0.4 // indirect
 	github.com/armon/go-metrics v0.4.1 // indirect
+	github.com/cenkalti/backoff v2.2.1+incompatible // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/fatih/color v1.13.0 // indirect
 	github.com/go-logr/logr v1.2.3 // indirect
@@ -43,6 +46,7 @@ require (
 	github.com/klauspost/compress v1.15.11 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.16 // indirect
+	github.com/miekg/dns v1.1.50 // indirect
 	github.com/mitchellh/go-homedir v1.1.0 // indirect
 	github.com/mitchellh/mapstructure v1.5.1-0.20220423185008-bf980b35cac4 // indirect
 	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
@@ -61,11 +65,12 @@ require (
 	go.opentelemetry.io/otel/sdk v1.7.0 // indirect
 	go.opentelemetry.io/otel/trace v1.7.0 // indirect
 	golang.org/x/crypto v0.0.0-20220926161630-eccd6366d1be // indirect
+	golang.org/x/mod v0.6.0-dev.0.20220419223038-86c51ed26bb4 // indirect
 	golang.org/x/net v0.0.0-20220927171203-f486391704dc // indirect
 	golang.org/x/sys v0.0.0-20220928140112-f11e5e49a4ec // indirect
 	golang.org/x/text v0.3.7 // indirect
+	golang.org/x/tools v0.1.11 // indirect
 	google.golang.org/genproto v0.0.0-20220622171453-ea41d75dfa0f // indirect
-	google.golang.org/protobuf v1.28.1 // indirect
 	gopkg.in/inf.v0 v0.9.1 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
@@ -77,4 +82,5 @@ require (
 )
 
 replace github.com/jordan-rash/wasmcloud-provider => github.com/taction/wasmcloud-provider v0.0.0-20221117060058-c4c7dbb96bac
+
 replace github.com/wasmcloud/interfaces/httpserver/tinygo => github.com/taction/interfaces/httpserver/tinygo v0.0.0-20221121060656-c8eb9f44fca4
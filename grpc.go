@@ -31,7 +31,10 @@ var clock = clocklib.New()
 // Maximum number of concurrent streams in a single gRPC connection
 // This is the default value used by gRPC servers and clients
 const grpcMaxConcurrentStreams = 100
-const maxConnIdle = 3 * time.Minute
+const defaultMaxConnIdle = 3 * time.Minute
+
+// minPurgeInterval bounds how often idle connections are purged.
+const minPurgeInterval = time.Second
 
 // ConnectionPool holds a pool of connections to the same address.
 type ConnectionPool struct {
@@ -230,6 +233,30 @@ func (p *ConnectionPool) Purge() {
 	p.connections = p.connections[:n]
 }
 
+// ConnectionStats describes a connection of a pool.
+type ConnectionStats struct {
+	// References is the number of calls using the connection.
+	References int32 `json:"references"`
+	// IdleSince is when the connection was last released, for unused connections.
+	IdleSince *time.Time `json:"idle_since,omitempty"`
+}
+
+// Stats describes the connections in the pool.
+func (p *ConnectionPool) Stats() []ConnectionStats {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	stats := make([]ConnectionStats, 0, len(p.connections))
+	for _, el := range p.connections {
+		s := ConnectionStats{References: atomic.LoadInt32(&el.referenceCount)}
+		if s.References <= 0 {
+			s.IdleSince = el.idleSince.Load()
+		}
+		stats = append(stats, s)
+	}
+	return stats
+}
+
 // connectionPoolConnection is used by connectionPool to store the connection.
 type connectionPoolConnection struct {
 	conn           grpc.ClientConnInterface
@@ -250,13 +277,41 @@ func (pic *connectionPoolConnection) MarkIdle() {
 
 // RemoteConnectionPool is used to hold connections to remote addresses.
 type RemoteConnectionPool struct {
-	pool *sync.Map
+	pool           *sync.Map
+	maxConnIdle    time.Duration
+	minActiveConns int
+	stop           chan struct{}
+	stopOnce       sync.Once
+}
+
+// NewRemoteConnectionPool creates a new RemoteConnectionPool object, which purges its idle connections
+// until DestroyAll is called.
+func NewRemoteConnectionPool(maxConnIdle time.Duration, minActiveConns int) *RemoteConnectionPool {
+	p := &RemoteConnectionPool{
+		pool:           &sync.Map{},
+		maxConnIdle:    maxConnIdle,
+		minActiveConns: minActiveConns,
+		stop:           make(chan struct{}),
+	}
+	go p.purgeLoop()
+	return p
 }
 
-// NewRemoteConnectionPool creates a new RemoteConnectionPool object.
-func NewRemoteConnectionPool() *RemoteConnectionPool {
-	return &RemoteConnectionPool{
-		pool: &sync.Map{},
+// purgeLoop purges the connections idle for longer than maxConnIdle every half of it.
+func (p *RemoteConnectionPool) purgeLoop() {
+	interval := p.maxConnIdle / 2
+	if interval < minPurgeInterval {
+		interval = minPurgeInterval
+	}
+	ticker := clock.Ticker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.Purge()
+		case <-p.stop:
+			return
+		}
 	}
 }
 
@@ -301,6 +356,16 @@ func (p *RemoteConnectionPool) Destroy(address string, conn grpc.ClientConnInter
 	item.(*ConnectionPool).Destroy(conn)
 }
 
+// DestroyAll closes all connections to every address and stops purging them.
+func (p *RemoteConnectionPool) DestroyAll() {
+	p.stopOnce.Do(func() { close(p.stop) })
+	p.pool.Range(func(address any, item any) bool {
+		item.(*ConnectionPool).DestroyAll()
+		p.pool.Delete(address)
+		return true
+	})
+}
+
 // Purge connections that have been idle for longer than maxConnIdle.
 // Note that this method should not be called by multiple goroutines at the same time.
 func (p *RemoteConnectionPool) Purge() {
@@ -310,11 +375,21 @@ func (p *RemoteConnectionPool) Purge() {
 	})
 }
 
+// Stats describes the connections to every address.
+func (p *RemoteConnectionPool) Stats() map[string][]ConnectionStats {
+	stats := map[string][]ConnectionStats{}
+	p.pool.Range(func(address any, item any) bool {
+		stats[address.(string)] = item.(*ConnectionPool).Stats()
+		return true
+	})
+	return stats
+}
+
 func (p *RemoteConnectionPool) loadOrStoreItem(address string) *ConnectionPool {
 	item, ok := p.pool.Load(address)
 	if !ok {
 		// Use LoadOrStore here in case another goroutine is in the exact same spot
-		item, _ = p.pool.LoadOrStore(address, NewConnectionPool(maxConnIdle, 0))
+		item, _ = p.pool.LoadOrStore(address, NewConnectionPool(p.maxConnIdle, p.minActiveConns))
 	}
 	return item.(*ConnectionPool)
 }
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+// closingConn is a pooled connection recording when it is closed.
+type closingConn struct {
+	grpc.ClientConnInterface
+	closed atomic.Bool
+}
+
+func (c *closingConn) Close() error {
+	c.closed.Store(true)
+	return nil
+}
+
+// idle connections are closed without anything calling Purge.
+func TestRemoteConnectionPoolPurge(t *testing.T) {
+	pool := NewRemoteConnectionPool(time.Millisecond, 0)
+	defer pool.DestroyAll()
+	conn := &closingConn{}
+	pool.Register("a", conn)
+
+	assert.Eventually(t, conn.closed.Load, 3*time.Second, 10*time.Millisecond)
+	assert.Empty(t, pool.Stats()["a"])
+}
@@ -0,0 +1,173 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultInterval         = 15 * time.Second
+	DefaultTimeout          = 5 * time.Second
+	DefaultFailureThreshold = 3
+	DefaultSuccessThreshold = 1
+)
+
+// ProbeFunc checks whether the target is reachable.
+type ProbeFunc func(ctx context.Context) error
+
+type Options struct {
+	Interval time.Duration
+	Timeout  time.Duration
+	// FailureThreshold is the number of consecutive failures before the target is unhealthy.
+	FailureThreshold int
+	// SuccessThreshold is the number of consecutive successes before an unhealthy target is healthy again.
+	SuccessThreshold int
+}
+
+func (o Options) withDefaults() Options {
+	if o.Interval <= 0 {
+		o.Interval = DefaultInterval
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = DefaultTimeout
+	}
+	if o.FailureThreshold <= 0 {
+		o.FailureThreshold = DefaultFailureThreshold
+	}
+	if o.SuccessThreshold <= 0 {
+		o.SuccessThreshold = DefaultSuccessThreshold
+	}
+	return o
+}
+
+// Checker keeps the health of a target from periodic probes and the results of real invocations.
+// A nil probe means the health only depends on reported invocation results.
+type Checker struct {
+	opts  Options
+	probe ProbeFunc
+	// recovery probes an unhealthy target which has no probe, it may get no invocation to report otherwise.
+	recovery ProbeFunc
+	onChange func(healthy bool)
+
+	l         sync.Mutex
+	healthy   bool
+	successes int
+	failures  int
+	// reset restarts the probe ticker after the options changed.
+	reset    chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewChecker(opts Options, probe ProbeFunc, onChange func(healthy bool)) *Checker {
+	return &Checker{
+		opts:     opts.withDefaults(),
+		probe:    probe,
+		onChange: onChange,
+		healthy:  true,
+		reset:    make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+	}
+}
+
+// NewPassiveChecker creates a checker whose health depends on reported invocation results. While the target
+// is unhealthy, recovery is run every Interval so that it can become healthy again without invocations.
+func NewPassiveChecker(opts Options, recovery ProbeFunc, onChange func(healthy bool)) *Checker {
+	c := NewChecker(opts, nil, onChange)
+	c.recovery = recovery
+	return c
+}
+
+// Start runs the probe loop in the background, it is a no-op without a probe or a recovery probe.
+func (c *Checker) Start() {
+	if c.probe == nil && c.recovery == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(c.options().Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.reset:
+				ticker.Reset(c.options().Interval)
+			case <-ticker.C:
+				if c.probe != nil {
+					c.Report(c.runProbe(c.probe))
+				} else if !c.Healthy() {
+					c.Report(c.runProbe(c.recovery))
+				}
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+}
+
+// SetOptions replaces the options of the checker, the probes follow the new interval from now on.
+func (c *Checker) SetOptions(opts Options) {
+	c.l.Lock()
+	c.opts = opts.withDefaults()
+	c.l.Unlock()
+	select {
+	case c.reset <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Checker) options() Options {
+	c.l.Lock()
+	defer c.l.Unlock()
+	return c.opts
+}
+
+func (c *Checker) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
+}
+
+func (c *Checker) runProbe(probe ProbeFunc) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.options().Timeout)
+	defer cancel()
+	errC := make(chan error, 1)
+	go func() {
+		errC <- probe(ctx)
+	}()
+	select {
+	case err := <-errC:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Report records the result of a probe or an invocation.
+func (c *Checker) Report(err error) {
+	c.l.Lock()
+	if err != nil {
+		c.successes = 0
+		c.failures++
+	} else {
+		c.failures = 0
+		c.successes++
+	}
+	changed := false
+	if c.healthy && c.failures >= c.opts.FailureThreshold {
+		c.healthy, changed = false, true
+	} else if !c.healthy && c.successes >= c.opts.SuccessThreshold {
+		c.healthy, changed = true, true
+	}
+	healthy := c.healthy
+	c.l.Unlock()
+
+	if changed && c.onChange != nil {
+		c.onChange(healthy)
+	}
+}
+
+func (c *Checker) Healthy() bool {
+	c.l.Lock()
+	defer c.l.Unlock()
+	return c.healthy
+}
@@ -0,0 +1,91 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReport(t *testing.T) {
+	var changes []bool
+	c := NewChecker(Options{FailureThreshold: 2, SuccessThreshold: 2}, nil, func(healthy bool) {
+		changes = append(changes, healthy)
+	})
+	errFailed := errors.New("failed")
+
+	c.Report(errFailed)
+	assert.True(t, c.Healthy())
+	c.Report(errFailed)
+	assert.False(t, c.Healthy())
+	c.Report(nil)
+	assert.False(t, c.Healthy())
+	c.Report(nil)
+	assert.True(t, c.Healthy())
+	assert.Equal(t, []bool{false, true}, changes)
+}
+
+func TestProbe(t *testing.T) {
+	unhealthy := make(chan struct{})
+	c := NewChecker(Options{Interval: time.Millisecond, Timeout: time.Millisecond, FailureThreshold: 1}, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, func(healthy bool) {
+		if !healthy {
+			close(unhealthy)
+		}
+	})
+	c.Start()
+	defer c.Stop()
+
+	select {
+	case <-unhealthy:
+	case <-time.After(time.Second):
+		t.Fatal("probe timeout not reported")
+	}
+}
+
+// a target marked unhealthy by invocations becomes healthy again through the recovery probe.
+func TestRecovery(t *testing.T) {
+	var recovered atomic.Bool
+	healthy := make(chan bool, 2)
+	c := NewPassiveChecker(Options{Interval: time.Millisecond, FailureThreshold: 1}, func(ctx context.Context) error {
+		if !recovered.Load() {
+			return errors.New("unreachable")
+		}
+		return nil
+	}, func(h bool) {
+		healthy <- h
+	})
+	c.Start()
+	defer c.Stop()
+
+	c.Report(errors.New("failed"))
+	assert.False(t, <-healthy)
+	time.Sleep(10 * time.Millisecond)
+	assert.False(t, c.Healthy())
+	recovered.Store(true)
+	select {
+	case h := <-healthy:
+		assert.True(t, h)
+	case <-time.After(time.Second):
+		t.Fatal("target not recovered")
+	}
+}
+
+// new options apply to the running probe loop.
+func TestSetOptions(t *testing.T) {
+	var probes atomic.Int32
+	c := NewChecker(Options{Interval: time.Hour}, func(ctx context.Context) error {
+		probes.Add(1)
+		return nil
+	}, nil)
+	c.Start()
+	defer c.Stop()
+
+	c.SetOptions(Options{Interval: time.Millisecond})
+	assert.Eventually(t, func() bool { return probes.Load() > 1 }, time.Second, time.Millisecond)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	provider "github.com/jordan-rash/wasmcloud-provider"
+	"github.com/nats-io/nats.go"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/taction/http-provider-go/transport"
+)
+
+// HealthCheckResponse is the reply to a host health check.
+type HealthCheckResponse struct {
+	Healthy bool   `msgpack:"healthy"`
+	Message string `msgpack:"message,omitempty"`
+}
+
+// bus is the part of the lattice connection the provider subscribes with.
+type bus interface {
+	Subscribe(subject string, cb nats.MsgHandler) (*nats.Subscription, error)
+	QueueSubscribe(subject, queue string, cb nats.MsgHandler) (*nats.Subscription, error)
+	Publish(subject string, data []byte) error
+	Flush() error
+}
+
+// initProvider reads the host data the host writes to r and connects to the lattice. It replaces provider.Init,
+// whose subscriptions answer health checks with an empty body and can't be removed: the host keeps the first
+// reply it gets, so the provider has to be the only one answering.
+func initProvider(r io.Reader) (provider.WasmcloudProvider, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil {
+		return provider.WasmcloudProvider{}, err
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(line))
+	if err != nil {
+		return provider.WasmcloudProvider{}, err
+	}
+	var hostData provider.HostData
+	if err := json.Unmarshal(raw, &hostData); err != nil {
+		return provider.WasmcloudProvider{}, err
+	}
+	nc, err := nats.Connect(hostData.LatticeRPCURL)
+	if err != nil {
+		return provider.WasmcloudProvider{}, err
+	}
+	return provider.WasmcloudProvider{
+		Links:          make(chan provider.LinkDefinition, 10),
+		Shutdown:       make(chan struct{}, 1),
+		NatsConnection: nc,
+		HostData:       hostData,
+		TopicData:      hostData.LatticeTopics(),
+	}, nil
+}
+
+// subscribeHost answers the host side of the provider protocol: link definitions, health checks with the status
+// of the links, and the acknowledgement of a shutdown once it is done.
+func (p *HttpServerProvider) subscribeHost(nc bus) error {
+	topics := p.Provider.HostData.LatticeTopics()
+	_, err := nc.QueueSubscribe(topics.LATTICE_LINKDEF_GET, topics.LATTICE_LINKDEF_GET, func(m *nats.Msg) {
+		msg, err := msgpack.Marshal(p.Provider.HostData.LinkDefinitions)
+		if err != nil {
+			log.Errorf("failed to encode link definitions: %s", err)
+			return
+		}
+		_ = nc.Publish(m.Reply, msg)
+	})
+	if err != nil {
+		return err
+	}
+	_, err = nc.Subscribe(topics.LATTICE_LINKDEF_PUT, func(m *nats.Msg) {
+		var ld provider.LinkDefinition
+		if err := msgpack.Unmarshal(m.Data, &ld); err != nil {
+			log.Errorf("failed to decode link definition: %s", err)
+			return
+		}
+		select {
+		case p.Provider.Links <- ld:
+		case <-p.done:
+		}
+	})
+	if err != nil {
+		return err
+	}
+	_, err = nc.Subscribe(topics.LATTICE_HEALTH, func(m *nats.Msg) {
+		msg, err := msgpack.Marshal(p.healthCheck())
+		if err != nil {
+			log.Errorf("failed to encode health check response: %s", err)
+			return
+		}
+		_ = nc.Publish(m.Reply, msg)
+	})
+	if err != nil {
+		return err
+	}
+	_, err = nc.Subscribe(topics.LATTICE_LINKDEF_DEL, func(m *nats.Msg) {
+		var ld provider.LinkDefinition
+		if err := msgpack.Unmarshal(m.Data, &ld); err != nil {
+			log.Errorf("failed to decode link definition: %s", err)
+			return
+		}
+		go func() {
+			if err := p.DeleteLink(ld.ActorID); err != nil {
+				log.Errorf("delete link for actor %s: %s", ld.ActorID, err)
+			}
+		}()
+	})
+	if err != nil {
+		return err
+	}
+	_, err = nc.Subscribe(topics.LATTICE_SHUTDOWN, func(m *nats.Msg) {
+		// the host may send the message again, only the first one starts the shutdown
+		select {
+		case p.Provider.Shutdown <- struct{}{}:
+		default:
+		}
+		// the drain of the links can take longer than the host waits for an answer, the host is answered
+		// right away and Run exits once everything is drained
+		if m.Reply != "" {
+			_ = nc.Publish(m.Reply, nil)
+			_ = nc.Flush()
+		}
+		p.ackOnce.Do(func() { close(p.acked) })
+	})
+	return err
+}
+
+// maxActorInvocations bounds the calls of actors handled at the same time, further calls are answered with
+// an error right away so that the subscription keeps up with the lattice.
+const maxActorInvocations = 256
+
+// subscribeActors answers the calls of linked actors. It replaces the subscription of the provider library,
+// which drops the origin of the calls that selects the routing values of the link of the calling actor.
+// Calls are handled concurrently, a slow app doesn't hold up the calls to the others.
+func (p *HttpServerProvider) subscribeActors(nc bus) error {
+	h := p.Provider.HostData
+	subject := fmt.Sprintf("wasmbus.rpc.%s.%s.%s", h.LatticeRPCPrefix, h.ProviderKey, h.LinkName)
+	_, err := nc.Subscribe(subject, func(m *nats.Msg) {
+		var inv provider.Invocation
+		if err := msgpack.Unmarshal(m.Data, &inv); err != nil {
+			log.Errorf("failed to decode invocation: %s", err)
+			return
+		}
+		select {
+		case p.invocations <- struct{}{}:
+		default:
+			log.Warnf("invocation %s rejected, %d calls in progress", inv.ID, cap(p.invocations))
+			p.replyInvocation(nc, m.Reply, provider.InvocationResponse{
+				InvocationID: inv.ID,
+				Error:        fmt.Sprintf("provider busy, %d calls in progress", cap(p.invocations)),
+			})
+			return
+		}
+		go func() {
+			defer func() { <-p.invocations }()
+			p.replyInvocation(nc, m.Reply, p.evaluateRequest(inv))
+		}()
+	})
+	return err
+}
+
+func (p *HttpServerProvider) replyInvocation(nc bus, reply string, resp provider.InvocationResponse) {
+	msg, err := msgpack.Marshal(resp)
+	if err != nil {
+		log.Errorf("failed to encode invocation response: %s", err)
+		msg, _ = msgpack.Marshal(provider.InvocationResponse{InvocationID: resp.InvocationID, Error: err.Error()})
+	}
+	_ = nc.Publish(reply, msg)
+}
+
+// healthCheck reports the provider healthy while the lattice connection is up and no link is rejected,
+// with a summary of the links.
+func (p *HttpServerProvider) healthCheck() HealthCheckResponse {
+	p.l.Lock()
+	var unhealthy []string
+	for actorID, l := range p.links {
+		if !l.serving {
+			unhealthy = append(unhealthy, fmt.Sprintf("%s(%s)", l.appID, actorID))
+		}
+	}
+	total := len(p.links)
+	var rejected []string
+	for _, err := range p.rejected {
+		rejected = append(rejected, err.Error())
+	}
+	restartNeeded := p.restartNeeded
+	p.l.Unlock()
+	sort.Strings(unhealthy)
+	sort.Strings(rejected)
+
+	state := p.conn.State()
+	msg := fmt.Sprintf("lattice %s, %d/%d links serving", state, total-len(unhealthy), total)
+	if len(unhealthy) > 0 {
+		msg += ", not serving: " + strings.Join(unhealthy, ", ")
+	}
+	// a rejected link is reported as unhealthy until it is fixed or deleted
+	if len(rejected) > 0 {
+		msg += ", " + strings.Join(rejected, ", ")
+	}
+	if len(restartNeeded) > 0 {
+		msg += ", restart needed to apply: " + strings.Join(restartNeeded, ", ")
+	}
+	return HealthCheckResponse{Healthy: state == transport.Connected && len(rejected) == 0, Message: msg}
+}
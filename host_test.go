@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	invokev1 "github.com/dapr/dapr/pkg/messaging/v1"
+	internalv1pb "github.com/dapr/dapr/pkg/proto/internals/v1"
+	provider "github.com/jordan-rash/wasmcloud-provider"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+	httpserver "github.com/wasmcloud/interfaces/httpserver/tinygo"
+
+	"github.com/taction/http-provider-go/discovery"
+	"github.com/taction/http-provider-go/encode"
+	"github.com/taction/http-provider-go/transport"
+)
+
+// fakeBus delivers requests to the handlers subscribed to their subject and keeps the replies.
+type fakeBus struct {
+	l       sync.Mutex
+	subs    map[string][]nats.MsgHandler
+	replies map[string][][]byte
+}
+
+func newFakeBus() *fakeBus {
+	return &fakeBus{subs: map[string][]nats.MsgHandler{}, replies: map[string][][]byte{}}
+}
+
+func (b *fakeBus) Subscribe(subject string, cb nats.MsgHandler) (*nats.Subscription, error) {
+	b.l.Lock()
+	defer b.l.Unlock()
+	b.subs[subject] = append(b.subs[subject], cb)
+	return nil, nil
+}
+
+func (b *fakeBus) QueueSubscribe(subject, _ string, cb nats.MsgHandler) (*nats.Subscription, error) {
+	return b.Subscribe(subject, cb)
+}
+
+func (b *fakeBus) Publish(subject string, data []byte) error {
+	b.l.Lock()
+	defer b.l.Unlock()
+	b.replies[subject] = append(b.replies[subject], data)
+	return nil
+}
+
+func (b *fakeBus) Flush() error { return nil }
+
+// request sends data to subject and returns every reply.
+func (b *fakeBus) request(subject string, data []byte) [][]byte {
+	reply := nats.NewInbox()
+	b.send(subject, reply, data)
+	return b.replied(reply)
+}
+
+// send delivers data to the handlers of subject.
+func (b *fakeBus) send(subject, reply string, data []byte) {
+	b.l.Lock()
+	subs := b.subs[subject]
+	b.l.Unlock()
+	for _, cb := range subs {
+		cb(&nats.Msg{Subject: subject, Reply: reply, Data: data})
+	}
+}
+
+func (b *fakeBus) replied(reply string) [][]byte {
+	b.l.Lock()
+	defer b.l.Unlock()
+	return b.replies[reply]
+}
+
+func newHostTestProvider() *HttpServerProvider {
+	p := NewHttpServerProvider()
+	p.conn = transport.NewConnectionMonitor(nil, transport.QueueOptions{})
+	p.Provider = provider.WasmcloudProvider{
+		Links:    make(chan provider.LinkDefinition, 10),
+		Shutdown: make(chan struct{}, 1),
+		HostData: provider.HostData{LatticeRPCPrefix: "default", ProviderKey: "VP", LinkName: "default"},
+	}
+	return p
+}
+
+func TestHealthCheck(t *testing.T) {
+	p := NewHttpServerProvider()
+	p.conn = transport.NewConnectionMonitor(nil, transport.QueueOptions{})
+	p.links["MA"] = &link{appID: "a", serving: true}
+	p.links["MB"] = &link{appID: "b"}
+
+	res := p.healthCheck()
+	assert.True(t, res.Healthy)
+	assert.Equal(t, "lattice connected, 1/2 links serving, not serving: b(MB)", res.Message)
+
+	p.rejected["MC"] = errors.New("link for actor MC rejected: unique_id is required")
+	res = p.healthCheck()
+	assert.False(t, res.Healthy)
+	assert.Equal(t, "lattice connected, 1/2 links serving, not serving: b(MB), link for actor MC rejected: unique_id is required", res.Message)
+}
+
+// the host gets a single reply to a health check, with the status of the links.
+func TestSubscribeHostHealth(t *testing.T) {
+	p := newHostTestProvider()
+	p.links["MA"] = &link{appID: "a", serving: true}
+	nc := newFakeBus()
+	require.NoError(t, p.subscribeHost(nc))
+
+	replies := nc.request(p.Provider.HostData.LatticeTopics().LATTICE_HEALTH, nil)
+	require.Len(t, replies, 1)
+	var res HealthCheckResponse
+	require.NoError(t, msgpack.Unmarshal(replies[0], &res))
+	assert.Equal(t, HealthCheckResponse{Healthy: true, Message: "lattice connected, 1/1 links serving"}, res)
+}
+
+// a shutdown is acknowledged before the links are drained, and again when the host sends it again.
+func TestSubscribeHostShutdownTwice(t *testing.T) {
+	p := newHostTestProvider()
+	nc := newFakeBus()
+	require.NoError(t, p.subscribeHost(nc))
+
+	topic := p.Provider.HostData.LatticeTopics().LATTICE_SHUTDOWN
+	assert.Len(t, nc.request(topic, nil), 1)
+	assert.Len(t, nc.request(topic, nil), 1)
+	assert.NotPanics(t, func() { nc.request(topic, nil) })
+	<-p.acked
+	// only the first message starts the shutdown, which Run hasn't picked up
+	assert.Len(t, p.Provider.Shutdown, 1)
+}
+
+// calls of actors fail once the call timeout is over.
+func TestEvaluateRequestTimeout(t *testing.T) {
+	var events []string
+	p := newTestProvider(&events)
+	p.config.Remote.CallTimeout = Duration{Duration: 10 * time.Millisecond}
+	target := &blockingServer{fakeServer: fakeServer{events: &events}, release: make(chan struct{})}
+	defer close(target.release)
+	p.Actors["MB"] = target
+	p.links["MB"] = &link{values: LinkConfig{AppID: "b", Protocol: discovery.ProtocolGRPC}, serving: true}
+	p.links["MA"] = &link{values: LinkConfig{AppID: "a", Protocol: discovery.ProtocolHTTP, DefaultTarget: "b"}}
+
+	msg, err := encode.Encode(&httpserver.HttpRequest{Method: "GET", Path: "stock", Body: []byte("{}"), Header: httpserver.HeaderMap{"accept": {"*/*"}}})
+	require.NoError(t, err)
+	res := p.evaluateRequest(provider.Invocation{
+		Origin:    provider.WasmCloudEntity{PublicKey: "MA"},
+		Operation: "HttpServer.HandleRequest",
+		Msg:       msg,
+	})
+	assert.Contains(t, res.Error, context.DeadlineExceeded.Error())
+}
+
+// blockingServer holds the calls it gets until released.
+type blockingServer struct {
+	fakeServer
+	internalv1pb.UnimplementedServiceInvocationServer
+	calls   atomic.Int32
+	release chan struct{}
+}
+
+func (s *blockingServer) CallLocal(ctx context.Context, in *internalv1pb.InternalInvokeRequest) (*internalv1pb.InternalInvokeResponse, error) {
+	s.calls.Add(1)
+	select {
+	case <-s.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return invokev1.NewInvokeMethodResponse(http.StatusOK, "", nil).Proto(), nil
+}
+
+// a slow call of an actor doesn't hold up the next ones.
+func TestSubscribeActorsConcurrently(t *testing.T) {
+	var events []string
+	p := newTestProvider(&events)
+	p.Provider.HostData = provider.HostData{LatticeRPCPrefix: "default", ProviderKey: "VP", LinkName: "default"}
+	target := &blockingServer{fakeServer: fakeServer{events: &events}, release: make(chan struct{})}
+	p.Actors["MB"] = target
+	p.links["MB"] = &link{values: LinkConfig{AppID: "b", Protocol: discovery.ProtocolGRPC}, serving: true}
+	p.links["MA"] = &link{values: LinkConfig{AppID: "a", Protocol: discovery.ProtocolHTTP, DefaultTarget: "b"}}
+	nc := newFakeBus()
+	require.NoError(t, p.subscribeActors(nc))
+
+	req := httpserver.HttpRequest{Method: "GET", Path: "stock", Body: []byte("{}"), Header: httpserver.HeaderMap{"accept": {"*/*"}}}
+	msg, err := encode.Encode(&req)
+	require.NoError(t, err)
+	data, err := msgpack.Marshal(provider.Invocation{
+		Origin:    provider.WasmCloudEntity{PublicKey: "MA"},
+		Operation: "HttpServer.HandleRequest",
+		Msg:       msg,
+	})
+	require.NoError(t, err)
+
+	subject := "wasmbus.rpc.default.VP.default"
+	replies := []string{nats.NewInbox(), nats.NewInbox()}
+	for _, reply := range replies {
+		go nc.send(subject, reply, data)
+	}
+	assert.Eventually(t, func() bool { return target.calls.Load() == 2 }, time.Second, time.Millisecond)
+
+	close(target.release)
+	for _, reply := range replies {
+		assert.Eventually(t, func() bool { return len(nc.replied(reply)) == 1 }, time.Second, time.Millisecond)
+		var res provider.InvocationResponse
+		require.NoError(t, msgpack.Unmarshal(nc.replied(reply)[0], &res))
+		assert.Empty(t, res.Error)
+	}
+
+	// calls over the limit are answered right away with an error
+	for i := 0; i < maxActorInvocations; i++ {
+		p.invocations <- struct{}{}
+	}
+	var res provider.InvocationResponse
+	replied := nc.request(subject, data)
+	require.Len(t, replied, 1)
+	require.NoError(t, msgpack.Unmarshal(replied[0], &res))
+	assert.Equal(t, "provider busy, 256 calls in progress", res.Error)
+}
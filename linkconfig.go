@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/taction/http-provider-go/discovery"
+)
+
+// Keys of the values of a link definition.
+const (
+	// linkAddress is the host:port the listener of the link binds.
+	linkAddress = "address"
+	// linkUniqueID is the dapr app id the actor is registered as.
+	linkUniqueID = "unique_id"
+	// linkProtocol selects the listener of a link: grpc for dapr service invocation (default) or http.
+	linkProtocol = "protocol"
+	// linkVersion is the version of the actor registered with the link.
+	linkVersion = "version"
+	// linkDefaultTarget is the app id of the calls of the actor without a dapr-app-id header and a matching route.
+	linkDefaultTarget = "default_target"
+	// linkRoutes maps paths of the calls of the actor without a dapr-app-id header to app ids,
+	// e.g. /orders/*:order-processor;/stock:inventory.
+	linkRoutes = "routes"
+	// linkStripHeaders lists the headers removed from the calls of the actor before they are forwarded,
+	// e.g. dapr-app-id;x-route.
+	linkStripHeaders = "strip_headers"
+)
+
+var linkKeys = []string{linkAddress, linkUniqueID, linkProtocol, linkVersion, healthProbePath, linkDefaultTarget, linkRoutes, linkStripHeaders}
+
+// linkListSeparator separates the entries of list values, link values can't contain commas.
+const linkListSeparator = ";"
+
+var (
+	// appIDPattern keeps app ids usable as consul service names and dns labels.
+	appIDPattern   = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9_-]*[A-Za-z0-9])?$`)
+	versionPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+	headerPattern  = regexp.MustCompile(`^[A-Za-z0-9!#$%&'*+.^_|~-]+$`)
+)
+
+// LinkConfig is the typed form of the values of a link definition.
+type LinkConfig struct {
+	Address         string `json:"address"`
+	AppID           string `json:"unique_id"`
+	Protocol        string `json:"protocol"`
+	Version         string `json:"version,omitempty"`
+	HealthProbePath string `json:"health_probe_path,omitempty"`
+	// Port is the port of Address.
+	Port          int      `json:"port"`
+	DefaultTarget string   `json:"default_target,omitempty"`
+	Routes        []Route  `json:"routes,omitempty"`
+	StripHeaders  []string `json:"strip_headers,omitempty"`
+}
+
+// Route sends the calls of an actor for a path to an app.
+type Route struct {
+	// Path is matched exactly, or as a prefix when it ends with *.
+	Path  string `json:"path"`
+	AppID string `json:"app_id"`
+}
+
+func (r Route) match(path string) bool {
+	if prefix := strings.TrimSuffix(r.Path, "*"); prefix != r.Path {
+		return strings.HasPrefix(path, prefix)
+	}
+	return path == r.Path
+}
+
+// target returns the app of a call to path without a dapr-app-id header: the app of the longest
+// matching route, else the default target.
+func (c LinkConfig) target(path string) string {
+	var best Route
+	for _, r := range c.Routes {
+		if r.match(path) && len(r.Path) > len(best.Path) {
+			best = r
+		}
+	}
+	if best.AppID != "" {
+		return best.AppID
+	}
+	return c.DefaultTarget
+}
+
+// parseLinkConfig validates the values of a link definition, every problem is reported at once.
+func parseLinkConfig(values map[string]string) (LinkConfig, error) {
+	c := LinkConfig{
+		Address:         values[linkAddress],
+		AppID:           values[linkUniqueID],
+		Protocol:        values[linkProtocol],
+		Version:         values[linkVersion],
+		HealthProbePath: values[healthProbePath],
+		DefaultTarget:   values[linkDefaultTarget],
+	}
+	var problems []string
+	for _, key := range sortedStringKeys(values) {
+		if !contains(linkKeys, key) {
+			problems = append(problems, fmt.Sprintf("unknown key %q, supported keys are %s", key, strings.Join(linkKeys, ", ")))
+		}
+	}
+
+	if c.Address == "" {
+		problems = append(problems, "address is required, e.g. address=0.0.0.0:8888")
+	} else if _, port, err := net.SplitHostPort(c.Address); err != nil {
+		problems = append(problems, fmt.Sprintf("address %q must be host:port, e.g. 0.0.0.0:8888", c.Address))
+	} else if c.Port, err = strconv.Atoi(port); err != nil || c.Port < 1 || c.Port > 65535 {
+		problems = append(problems, fmt.Sprintf("address %q needs a port between 1 and 65535", c.Address))
+	}
+
+	if c.AppID == "" {
+		problems = append(problems, "unique_id is required, it is the dapr app id of the actor")
+	} else if !appIDPattern.MatchString(c.AppID) {
+		problems = append(problems, fmt.Sprintf("unique_id %q may only contain letters, digits, '-' and '_', and must start and end with a letter or digit", c.AppID))
+	}
+
+	switch c.Protocol {
+	case "":
+		c.Protocol = discovery.ProtocolGRPC
+	case discovery.ProtocolGRPC, discovery.ProtocolHTTP:
+	default:
+		problems = append(problems, fmt.Sprintf("protocol %q is not one of grpc, http", c.Protocol))
+	}
+
+	if c.Version != "" && !versionPattern.MatchString(c.Version) {
+		problems = append(problems, fmt.Sprintf("version %q may only contain letters, digits, '.', '-' and '_'", c.Version))
+	}
+	if c.HealthProbePath != "" && !strings.HasPrefix(c.HealthProbePath, "/") {
+		problems = append(problems, fmt.Sprintf("health_probe_path %q must start with /", c.HealthProbePath))
+	}
+
+	if c.DefaultTarget != "" && !appIDPattern.MatchString(c.DefaultTarget) {
+		problems = append(problems, fmt.Sprintf("default_target %q is not a valid app id", c.DefaultTarget))
+	}
+	for _, entry := range splitList(values[linkRoutes]) {
+		path, appID, ok := strings.Cut(entry, ":")
+		switch {
+		case !ok || !strings.HasPrefix(path, "/"):
+			problems = append(problems, fmt.Sprintf("routes entry %q must be <path>:<app id>, e.g. /orders/*:order-processor", entry))
+		case strings.Contains(strings.TrimSuffix(path, "*"), "*"):
+			problems = append(problems, fmt.Sprintf("routes path %q may only end with *", path))
+		case !appIDPattern.MatchString(appID):
+			problems = append(problems, fmt.Sprintf("routes app id %q of %s is not a valid app id", appID, path))
+		default:
+			c.Routes = append(c.Routes, Route{Path: path, AppID: appID})
+		}
+	}
+	for _, name := range splitList(values[linkStripHeaders]) {
+		if !headerPattern.MatchString(name) {
+			problems = append(problems, fmt.Sprintf("strip_headers %q is not a header name", name))
+		} else {
+			c.StripHeaders = append(c.StripHeaders, name)
+		}
+	}
+
+	if len(problems) > 0 {
+		return c, errors.New(strings.Join(problems, "; "))
+	}
+	return c, nil
+}
+
+// splitList returns the non-empty entries of a list value.
+func splitList(value string) []string {
+	var list []string
+	for _, entry := range strings.Split(value, linkListSeparator) {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLinkConfig(t *testing.T) {
+	c, err := parseLinkConfig(map[string]string{"address": "0.0.0.0:8888", "unique_id": "order-processor", "version": "v1.2"})
+	require.NoError(t, err)
+	assert.Equal(t, LinkConfig{Address: "0.0.0.0:8888", AppID: "order-processor", Protocol: "grpc", Version: "v1.2", Port: 8888}, c)
+
+	errs := map[string]map[string]string{
+		`address is required, e.g. address=0.0.0.0:8888; unique_id is required, it is the dapr app id of the actor`: {},
+		`address "8888" must be host:port, e.g. 0.0.0.0:8888`:                                                       {"address": "8888", "unique_id": "a"},
+		`address ":0" needs a port between 1 and 65535`:                                                             {"address": ":0", "unique_id": "a"},
+		`unique_id "order processor" may only contain letters, digits, '-' and '_', and must start and end with a letter or digit`: {
+			"address": ":8888", "unique_id": "order processor",
+		},
+		`unknown key "adress", supported keys are address, unique_id, protocol, version, health_probe_path, default_target, routes, strip_headers; address is required, e.g. address=0.0.0.0:8888`: {
+			"adress": ":8888", "unique_id": "a",
+		},
+		`protocol "tcp" is not one of grpc, http; version "v 1" may only contain letters, digits, '.', '-' and '_'; health_probe_path "healthz" must start with /`: {
+			"address": ":8888", "unique_id": "a", "protocol": "tcp", "version": "v 1", "health_probe_path": "healthz",
+		},
+		`default_target "order processor" is not a valid app id; routes entry "orders:a" must be <path>:<app id>, e.g. /orders/*:order-processor; routes path "/*/items" may only end with *; routes app id "" of /stock is not a valid app id; strip_headers "x route" is not a header name`: {
+			"address": ":8888", "unique_id": "a", "default_target": "order processor",
+			"routes": "orders:a;/*/items:b;/stock:", "strip_headers": "x route",
+		},
+	}
+	for msg, values := range errs {
+		_, err := parseLinkConfig(values)
+		assert.EqualError(t, err, msg)
+	}
+}
+
+func TestLinkTarget(t *testing.T) {
+	c, err := parseLinkConfig(map[string]string{
+		"address":        "0.0.0.0:8888",
+		"unique_id":      "a",
+		"default_target": "fallback",
+		"routes":         "/orders/*:order-processor; /orders/stock/*:inventory;/health:status;",
+		"strip_headers":  "dapr-app-id;x-route",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dapr-app-id", "x-route"}, c.StripHeaders)
+
+	targets := map[string]string{
+		"/orders/1":         "order-processor",
+		"/orders/stock/1":   "inventory",
+		"/health":           "status",
+		"/health/ready":     "fallback",
+		"/checkout":         "fallback",
+		"/orders":           "fallback",
+		"/orders/stock/":    "inventory",
+		"/orders/stockroom": "order-processor",
+	}
+	for path, appID := range targets {
+		assert.Equal(t, appID, c.target(path), path)
+	}
+	c.DefaultTarget = ""
+	assert.Equal(t, "", c.target("/checkout"))
+}
@@ -0,0 +1,355 @@
+package loadbalancer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+	"google.golang.org/grpc/status"
+
+	"github.com/taction/http-provider-go/discovery"
+	"github.com/taction/http-provider-go/duration"
+)
+
+// Name is the name of the balancer in the grpc service config.
+const Name = "wasmcloud"
+
+const (
+	PolicyRoundRobin = "round_robin"
+	PolicyWeighted   = "weighted"
+	PolicyLocality   = "locality"
+)
+
+const (
+	defaultBaseEjectionTime   = 30 * time.Second
+	defaultMaxEjectionPercent = 50
+	maxEjectionMultiplier     = 10
+)
+
+// Options configures the balancer of the connections to remote apps.
+type Options struct {
+	// Policy is one of round_robin (default), weighted or locality.
+	Policy string `json:"policy,omitempty"`
+	// Locality is preferred by the locality policy while it has ready endpoints.
+	Locality string         `json:"locality,omitempty"`
+	Outlier  OutlierOptions `json:"outlier"`
+	// Versions splits the calls without a version between the versions by weight, the calls of a version
+	// without ready endpoints go to any endpoint.
+	Versions map[string]int `json:"versions,omitempty"`
+}
+
+// OutlierOptions ejects an endpoint for a while after ConsecutiveFailures failed calls in a row, 0 disables ejection.
+type OutlierOptions struct {
+	ConsecutiveFailures int `json:"consecutiveFailures,omitempty"`
+	// BaseEjectionTime is multiplied by the number of times the endpoint got ejected in a row.
+	BaseEjectionTime duration.Duration `json:"baseEjectionTime,omitempty"`
+	// MaxEjectionPercent of the endpoints can be ejected at the same time.
+	MaxEjectionPercent int `json:"maxEjectionPercent,omitempty"`
+}
+
+// ServiceConfig returns the grpc service config selecting the balancer configured with opts.
+func ServiceConfig(opts Options) string {
+	b, _ := json.Marshal(map[string]interface{}{
+		"loadBalancingConfig": []interface{}{map[string]interface{}{Name: opts}},
+	})
+	return string(b)
+}
+
+func init() {
+	balancer.Register(builder{})
+}
+
+type lbConfig struct {
+	serviceconfig.LoadBalancingConfig
+	Options
+}
+
+type builder struct{}
+
+func (builder) Name() string {
+	return Name
+}
+
+func (builder) ParseConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	cfg := &lbConfig{}
+	if err := json.Unmarshal(js, &cfg.Options); err != nil {
+		return nil, fmt.Errorf("invalid %s balancer config: %w", Name, err)
+	}
+	switch cfg.Policy {
+	case "", PolicyRoundRobin, PolicyWeighted, PolicyLocality:
+	default:
+		return nil, fmt.Errorf("unknown load balancing policy %q", cfg.Policy)
+	}
+	for version, weight := range cfg.Versions {
+		if weight < 0 {
+			return nil, fmt.Errorf("negative weight of version %s", version)
+		}
+	}
+	if cfg.Outlier.BaseEjectionTime.Duration <= 0 {
+		cfg.Outlier.BaseEjectionTime.Duration = defaultBaseEjectionTime
+	}
+	if cfg.Outlier.MaxEjectionPercent <= 0 {
+		cfg.Outlier.MaxEjectionPercent = defaultMaxEjectionPercent
+	}
+	return cfg, nil
+}
+
+func (builder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	pb := newPickerBuilder()
+	return &wasmcloudBalancer{
+		Balancer: base.NewBalancerBuilder(Name, pb, base.Config{}).Build(cc, opts),
+		pb:       pb,
+	}
+}
+
+// wasmcloudBalancer is the base balancer which tells the picker builder about the config and the
+// endpoints, the base balancer only keeps the first address it sees for a sub connection.
+type wasmcloudBalancer struct {
+	balancer.Balancer
+	pb *pickerBuilder
+}
+
+func (b *wasmcloudBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	opts := Options{}
+	if cfg, ok := s.BalancerConfig.(*lbConfig); ok {
+		opts = cfg.Options
+	}
+	b.pb.update(opts, s.ResolverState.Addresses)
+	return b.Balancer.UpdateClientConnState(s)
+}
+
+type outlier struct {
+	failures     int
+	ejections    int
+	ejectedUntil time.Time
+}
+
+// pickerBuilder keeps the state shared by the pickers of a connection.
+type pickerBuilder struct {
+	l         sync.Mutex
+	options   Options
+	endpoints map[string]discovery.Endpoint
+	outliers  map[string]*outlier
+	now       func() time.Time
+}
+
+func newPickerBuilder() *pickerBuilder {
+	return &pickerBuilder{
+		endpoints: make(map[string]discovery.Endpoint),
+		outliers:  make(map[string]*outlier),
+		now:       time.Now,
+	}
+}
+
+func (pb *pickerBuilder) update(opts Options, addrs []resolver.Address) {
+	pb.l.Lock()
+	defer pb.l.Unlock()
+	pb.options = opts
+	pb.endpoints = make(map[string]discovery.Endpoint, len(addrs))
+	for _, addr := range addrs {
+		pb.endpoints[addr.Addr] = endpointOf(addr)
+	}
+	for addr := range pb.outliers {
+		if _, ok := pb.endpoints[addr]; !ok {
+			delete(pb.outliers, addr)
+		}
+	}
+}
+
+func (pb *pickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	pb.l.Lock()
+	defer pb.l.Unlock()
+
+	p := &picker{
+		pb:        pb,
+		weighted:  pb.options.Policy == PolicyWeighted,
+		byVersion: map[string][]*pickEntry{},
+		split:     newVersionSplit(pb.options.Versions),
+	}
+	if pb.options.Policy == PolicyLocality {
+		p.locality = pb.options.Locality
+	}
+	for sc, sci := range info.ReadySCs {
+		e, ok := pb.endpoints[sci.Address.Addr]
+		if !ok {
+			e = discovery.Endpoint{Address: sci.Address.Addr}
+		}
+		if e.Weight <= 0 {
+			e.Weight = 1
+		}
+		p.all = append(p.all, &pickEntry{sc: sc, endpoint: e})
+	}
+	sort.Slice(p.all, func(i, j int) bool { return p.all[i].endpoint.Address < p.all[j].endpoint.Address })
+	for _, e := range p.all {
+		if e.endpoint.Version != "" {
+			p.byVersion[e.endpoint.Version] = append(p.byVersion[e.endpoint.Version], e)
+		}
+	}
+	return p
+}
+
+// available filters out the ejected entries.
+func (pb *pickerBuilder) available(entries []*pickEntry) []*pickEntry {
+	pb.l.Lock()
+	defer pb.l.Unlock()
+	if pb.options.Outlier.ConsecutiveFailures <= 0 {
+		return entries
+	}
+	now := pb.now()
+	available := make([]*pickEntry, 0, len(entries))
+	for _, e := range entries {
+		if o, ok := pb.outliers[e.endpoint.Address]; ok && now.Before(o.ejectedUntil) {
+			continue
+		}
+		available = append(available, e)
+	}
+	return available
+}
+
+// record counts the consecutive failures of addr and ejects it once it reaches the threshold.
+func (pb *pickerBuilder) record(addr string, err error) {
+	pb.l.Lock()
+	defer pb.l.Unlock()
+	opts := pb.options.Outlier
+	if opts.ConsecutiveFailures <= 0 {
+		return
+	}
+	o, ok := pb.outliers[addr]
+	if !ok {
+		o = &outlier{}
+		pb.outliers[addr] = o
+	}
+	now := pb.now()
+	if !isFailure(err) {
+		o.failures = 0
+		if !now.Before(o.ejectedUntil) {
+			o.ejections = 0
+		}
+		return
+	}
+	o.failures++
+	if o.failures < opts.ConsecutiveFailures || now.Before(o.ejectedUntil) {
+		return
+	}
+
+	ejected := 0
+	for _, other := range pb.outliers {
+		if now.Before(other.ejectedUntil) {
+			ejected++
+		}
+	}
+	if (ejected+1)*100 > opts.MaxEjectionPercent*len(pb.endpoints) {
+		return
+	}
+	if o.ejections < maxEjectionMultiplier {
+		o.ejections++
+	}
+	o.failures = 0
+	o.ejectedUntil = now.Add(opts.BaseEjectionTime.Duration * time.Duration(o.ejections))
+	log.Warnf("ejected endpoint %s until %s after %d consecutive failures", addr, o.ejectedUntil, opts.ConsecutiveFailures)
+}
+
+func isFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Internal, codes.Unknown:
+		return true
+	}
+	return false
+}
+
+type pickEntry struct {
+	sc       balancer.SubConn
+	endpoint discovery.Endpoint
+	current  int
+}
+
+type picker struct {
+	pb        *pickerBuilder
+	weighted  bool
+	locality  string
+	all       []*pickEntry
+	byVersion map[string][]*pickEntry
+	split     *versionSplit
+
+	l    sync.Mutex
+	next int
+}
+
+func (p *picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	entries := p.all
+	if version := versionFromContext(info.Ctx); version != "" {
+		entries = p.byVersion[version]
+		if len(entries) == 0 {
+			return balancer.PickResult{}, status.Errorf(codes.Unavailable, "no ready endpoint of version %s", version)
+		}
+	} else if version := p.split.pick(); len(p.byVersion[version]) > 0 {
+		entries = p.byVersion[version]
+	}
+
+	var candidates []*pickEntry
+	if p.locality != "" {
+		candidates = p.pb.available(p.local(entries))
+	}
+	if len(candidates) == 0 {
+		candidates = p.pb.available(entries)
+	}
+	if len(candidates) == 0 {
+		candidates = entries
+	}
+
+	p.l.Lock()
+	var e *pickEntry
+	if p.weighted {
+		e = smoothWeighted(candidates)
+	} else {
+		e = candidates[p.next%len(candidates)]
+		p.next++
+	}
+	p.l.Unlock()
+
+	addr := e.endpoint.Address
+	return balancer.PickResult{
+		SubConn: e.sc,
+		Done: func(info balancer.DoneInfo) {
+			p.pb.record(addr, info.Err)
+		},
+	}, nil
+}
+
+func (p *picker) local(entries []*pickEntry) []*pickEntry {
+	local := make([]*pickEntry, 0, len(entries))
+	for _, e := range entries {
+		if e.endpoint.Locality == p.locality {
+			local = append(local, e)
+		}
+	}
+	return local
+}
+
+// smoothWeighted is nginx's smooth weighted round robin, it spreads the picks of heavy entries.
+func smoothWeighted(entries []*pickEntry) *pickEntry {
+	var best *pickEntry
+	total := 0
+	for _, e := range entries {
+		e.current += e.endpoint.Weight
+		total += e.endpoint.Weight
+		if best == nil || e.current > best.current {
+			best = e
+		}
+	}
+	best.current -= total
+	return best
+}
@@ -0,0 +1,281 @@
+package loadbalancer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	nr "github.com/dapr/components-contrib/nameresolution"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+	"google.golang.org/grpc/status"
+
+	"github.com/taction/http-provider-go/discovery"
+	"github.com/taction/http-provider-go/duration"
+)
+
+type fakeSubConn struct {
+	name string
+}
+
+func (s *fakeSubConn) UpdateAddresses([]resolver.Address) {}
+func (s *fakeSubConn) Connect()                           {}
+
+func buildPicker(t *testing.T, pb *pickerBuilder, opts Options, endpoints ...discovery.Endpoint) balancer.Picker {
+	t.Helper()
+	addrs := make([]resolver.Address, 0, len(endpoints))
+	info := base.PickerBuildInfo{ReadySCs: map[balancer.SubConn]base.SubConnInfo{}}
+	for _, e := range endpoints {
+		addr := resolver.Address{Addr: e.Address, BalancerAttributes: attributes.New(endpointKey{}, e)}
+		addrs = append(addrs, addr)
+		// the base balancer keeps the address without attributes
+		info.ReadySCs[&fakeSubConn{name: e.Address}] = base.SubConnInfo{Address: resolver.Address{Addr: e.Address}}
+	}
+	pb.update(opts, addrs)
+	return pb.Build(info)
+}
+
+func pickN(t *testing.T, p balancer.Picker, n int, err error) map[string]int {
+	t.Helper()
+	picks := map[string]int{}
+	for i := 0; i < n; i++ {
+		res, perr := p.Pick(balancer.PickInfo{})
+		require.NoError(t, perr)
+		picks[res.SubConn.(*fakeSubConn).name]++
+		res.Done(balancer.DoneInfo{Err: err})
+	}
+	return picks
+}
+
+func TestPicker(t *testing.T) {
+	a := discovery.Endpoint{Address: "10.0.0.1:50002", Weight: 1, Locality: "zone-a"}
+	b := discovery.Endpoint{Address: "10.0.0.2:50002", Weight: 3, Locality: "zone-b"}
+	c := discovery.Endpoint{Address: "10.0.0.3:50002", Locality: "zone-b"}
+
+	t.Run("round robin", func(t *testing.T) {
+		p := buildPicker(t, newPickerBuilder(), Options{}, a, b, c)
+		assert.Equal(t, map[string]int{a.Address: 2, b.Address: 2, c.Address: 2}, pickN(t, p, 6, nil))
+	})
+
+	t.Run("weighted", func(t *testing.T) {
+		p := buildPicker(t, newPickerBuilder(), Options{Policy: PolicyWeighted}, a, b, c)
+		assert.Equal(t, map[string]int{a.Address: 2, b.Address: 6, c.Address: 2}, pickN(t, p, 10, nil))
+	})
+
+	t.Run("locality", func(t *testing.T) {
+		pb := newPickerBuilder()
+		p := buildPicker(t, pb, Options{Policy: PolicyLocality, Locality: "zone-b"}, a, b, c)
+		assert.Equal(t, map[string]int{b.Address: 2, c.Address: 2}, pickN(t, p, 4, nil))
+
+		p = buildPicker(t, pb, Options{Policy: PolicyLocality, Locality: "zone-c"}, a, b, c)
+		assert.Len(t, pickN(t, p, 3, nil), 3)
+	})
+
+	t.Run("outlier ejection", func(t *testing.T) {
+		now := time.Now()
+		pb := newPickerBuilder()
+		pb.now = func() time.Time { return now }
+		opts := Options{Outlier: OutlierOptions{ConsecutiveFailures: 2, BaseEjectionTime: duration.Duration{Duration: time.Minute}, MaxEjectionPercent: 50}}
+		p := buildPicker(t, pb, opts, a, b)
+		unavailable := status.Error(codes.Unavailable, "down")
+
+		// a fails twice in a row and gets ejected
+		pickN(t, p, 4, unavailable)
+		// b failed as often but can't be ejected too with MaxEjectionPercent 50
+		assert.Equal(t, map[string]int{b.Address: 4}, pickN(t, p, 4, nil))
+
+		now = now.Add(2 * time.Minute)
+		assert.Len(t, pickN(t, p, 4, nil), 2)
+	})
+
+	t.Run("application errors don't eject", func(t *testing.T) {
+		pb := newPickerBuilder()
+		opts := Options{Outlier: OutlierOptions{ConsecutiveFailures: 1, BaseEjectionTime: duration.Duration{Duration: time.Minute}, MaxEjectionPercent: 100}}
+		p := buildPicker(t, pb, opts, a, b)
+		pickN(t, p, 4, status.Error(codes.NotFound, "missing"))
+		assert.Len(t, pickN(t, p, 4, nil), 2)
+	})
+}
+
+func TestPickerVersions(t *testing.T) {
+	v1 := discovery.Endpoint{Address: "10.0.0.1:50002", Version: "v1"}
+	v2 := discovery.Endpoint{Address: "10.0.0.2:50002", Version: "v2"}
+	unversioned := discovery.Endpoint{Address: "10.0.0.3:50002"}
+
+	t.Run("version of the call", func(t *testing.T) {
+		p := buildPicker(t, newPickerBuilder(), Options{}, v1, v2, unversioned)
+		for i := 0; i < 3; i++ {
+			res, err := p.Pick(balancer.PickInfo{Ctx: WithVersion(context.Background(), "v2")})
+			require.NoError(t, err)
+			assert.Equal(t, v2.Address, res.SubConn.(*fakeSubConn).name)
+		}
+		_, err := p.Pick(balancer.PickInfo{Ctx: WithVersion(context.Background(), "v3")})
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+	})
+
+	t.Run("weighted split", func(t *testing.T) {
+		p := buildPicker(t, newPickerBuilder(), Options{Versions: map[string]int{"v1": 0, "v2": 1}}, v1, v2, unversioned)
+		assert.Equal(t, map[string]int{v2.Address: 10}, pickN(t, p, 10, nil))
+
+		p = buildPicker(t, newPickerBuilder(), Options{Versions: map[string]int{"v1": 3, "v2": 1}}, v1, v2)
+		picks := pickN(t, p, 400, nil)
+		assert.Greater(t, picks[v1.Address], picks[v2.Address])
+		assert.Greater(t, picks[v2.Address], 0)
+	})
+
+	t.Run("split to a version without endpoints", func(t *testing.T) {
+		p := buildPicker(t, newPickerBuilder(), Options{Versions: map[string]int{"v3": 1}}, v1, v2)
+		assert.Len(t, pickN(t, p, 4, nil), 2)
+	})
+}
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := builder{}.ParseConfig([]byte(`{"policy":"weighted","outlier":{"consecutiveFailures":3}}`))
+	require.NoError(t, err)
+	opts := cfg.(*lbConfig).Options
+	assert.Equal(t, PolicyWeighted, opts.Policy)
+	assert.Equal(t, defaultBaseEjectionTime, opts.Outlier.BaseEjectionTime.Duration)
+	assert.Equal(t, defaultMaxEjectionPercent, opts.Outlier.MaxEjectionPercent)
+
+	_, err = builder{}.ParseConfig([]byte(`{"policy":"random"}`))
+	assert.Error(t, err)
+
+	// the service config carries the ejection time like the provider configuration
+	js := ServiceConfig(Options{Outlier: OutlierOptions{BaseEjectionTime: duration.Duration{Duration: time.Minute}}})
+	assert.Contains(t, js, `"baseEjectionTime":"1m0s"`)
+	cfg, err = builder{}.ParseConfig([]byte(`{"outlier":{"baseEjectionTime":"1m"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, cfg.(*lbConfig).Outlier.BaseEjectionTime.Duration)
+}
+
+type fakeDiscover struct {
+	l         sync.Mutex
+	endpoints []discovery.Endpoint
+	err       error
+}
+
+func (d *fakeDiscover) Init(nr.Metadata) error { return nil }
+func (d *fakeDiscover) ResolveID(nr.ResolveRequest) (string, error) {
+	return "", errors.New("not used")
+}
+func (d *fakeDiscover) RegisterToDiscovery(discovery.App) error { return nil }
+func (d *fakeDiscover) RemoveFromDiscovery(discovery.App)       {}
+func (d *fakeDiscover) ResolveEndpoints(nr.ResolveRequest) ([]discovery.Endpoint, error) {
+	d.l.Lock()
+	defer d.l.Unlock()
+	return append([]discovery.Endpoint{}, d.endpoints...), d.err
+}
+
+type fakeClientConn struct {
+	resolver.ClientConn
+	l      sync.Mutex
+	states []resolver.State
+	errs   []error
+}
+
+func (cc *fakeClientConn) UpdateState(s resolver.State) error {
+	cc.l.Lock()
+	defer cc.l.Unlock()
+	cc.states = append(cc.states, s)
+	return nil
+}
+
+func (cc *fakeClientConn) ReportError(err error) {
+	cc.l.Lock()
+	defer cc.l.Unlock()
+	cc.errs = append(cc.errs, err)
+}
+
+func (cc *fakeClientConn) ParseServiceConfig(string) *serviceconfig.ParseResult { return nil }
+
+func (cc *fakeClientConn) counts() (int, int) {
+	cc.l.Lock()
+	defer cc.l.Unlock()
+	return len(cc.states), len(cc.errs)
+}
+
+func TestResolver(t *testing.T) {
+	d := &fakeDiscover{endpoints: []discovery.Endpoint{{Address: "10.0.0.2:50002", Weight: 2}, {Address: "10.0.0.1:50002"}}}
+	cc := &fakeClientConn{}
+	target := resolver.Target{}
+	target.URL.Scheme, target.URL.Path = Scheme, "/app"
+	r, err := NewResolverBuilder(d, time.Hour).Build(target, cc, resolver.BuildOptions{})
+	require.NoError(t, err)
+	defer r.Close()
+
+	assert.Eventually(t, func() bool { s, _ := cc.counts(); return s == 1 }, time.Second, 10*time.Millisecond)
+	cc.l.Lock()
+	addrs := cc.states[0].Addresses
+	cc.l.Unlock()
+	require.Len(t, addrs, 2)
+	assert.Equal(t, "10.0.0.1:50002", addrs[0].Addr)
+	assert.Equal(t, 2, endpointOf(addrs[1]).Weight)
+
+	// unchanged endpoints are not pushed again
+	r.ResolveNow(resolver.ResolveNowOptions{})
+	time.Sleep(50 * time.Millisecond)
+	s, _ := cc.counts()
+	assert.Equal(t, 1, s)
+
+	d.l.Lock()
+	d.err = errors.New("unreachable")
+	d.l.Unlock()
+	r.ResolveNow(resolver.ResolveNowOptions{})
+	assert.Eventually(t, func() bool { _, e := cc.counts(); return e == 1 }, time.Second, 10*time.Millisecond)
+}
+
+// replica answers health checks with its name in the response header.
+type replica struct {
+	healthpb.UnimplementedHealthServer
+	name string
+}
+
+func (r *replica) Check(ctx context.Context, _ *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	_ = grpc.SetHeader(ctx, metadata.Pairs("replica", r.name))
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil //nolint:nosnakecase
+}
+
+func TestBalancingAcrossReplicas(t *testing.T) {
+	d := &fakeDiscover{}
+	for _, name := range []string{"a", "b", "c"} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		s := grpc.NewServer()
+		healthpb.RegisterHealthServer(s, &replica{name: name})
+		go func() { _ = s.Serve(ln) }()
+		defer s.Stop()
+		d.endpoints = append(d.endpoints, discovery.Endpoint{Address: ln.Addr().String(), Weight: 1})
+	}
+
+	conn, err := grpc.Dial(Target("app"),
+		grpc.WithResolvers(NewResolverBuilder(d, time.Hour)),
+		grpc.WithDefaultServiceConfig(ServiceConfig(Options{})),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client := healthpb.NewHealthClient(conn)
+	calls := map[string]int{}
+	assert.Eventually(t, func() bool {
+		var md metadata.MD
+		_, err := client.Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true), grpc.Header(&md))
+		require.NoError(t, err)
+		calls[md.Get("replica")[0]]++
+		return len(calls) == 3
+	}, 5*time.Second, time.Millisecond)
+}
@@ -0,0 +1,151 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dapr/components-contrib/nameresolution"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/resolver"
+
+	"github.com/taction/http-provider-go/discovery"
+	logging "github.com/taction/http-provider-go/log"
+)
+
+// Scheme is the dial target scheme of app ids resolved with discovery.
+const Scheme = "wasmcloud"
+
+const defaultRefreshInterval = 10 * time.Second
+
+var log = logging.NewLogger("wasmcloud.loadbalancer")
+
+type endpointKey struct{}
+
+// Target returns the dial target of appID.
+func Target(appID string) string {
+	return Scheme + ":///" + appID
+}
+
+type resolverBuilder struct {
+	d        discovery.Discover
+	interval time.Duration
+}
+
+// NewResolverBuilder returns a grpc resolver, to dial with grpc.WithResolvers, which pushes every endpoint
+// of an app id into the connection and refreshes them every interval.
+func NewResolverBuilder(d discovery.Discover, interval time.Duration) resolver.Builder {
+	if interval <= 0 {
+		interval = defaultRefreshInterval
+	}
+	return &resolverBuilder{d: d, interval: interval}
+}
+
+func (b *resolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	appID := strings.TrimPrefix(target.URL.Path, "/")
+	if appID == "" {
+		return nil, fmt.Errorf("missing app id in target %s", target.URL.String())
+	}
+	r := &appResolver{
+		d:          b.d,
+		appID:      appID,
+		cc:         cc,
+		interval:   b.interval,
+		resolveNow: make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	go r.watch()
+	return r, nil
+}
+
+func (b *resolverBuilder) Scheme() string {
+	return Scheme
+}
+
+type appResolver struct {
+	d          discovery.Discover
+	appID      string
+	cc         resolver.ClientConn
+	interval   time.Duration
+	resolveNow chan struct{}
+	done       chan struct{}
+	closeOnce  sync.Once
+	last       []discovery.Endpoint
+}
+
+func (r *appResolver) ResolveNow(resolver.ResolveNowOptions) {
+	select {
+	case r.resolveNow <- struct{}{}:
+	default:
+	}
+}
+
+func (r *appResolver) Close() {
+	r.closeOnce.Do(func() { close(r.done) })
+}
+
+func (r *appResolver) watch() {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		r.resolve()
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+		case <-r.resolveNow:
+		}
+	}
+}
+
+func (r *appResolver) resolve() {
+	endpoints, err := ResolveEndpoints(r.d, r.appID)
+	if err == nil && len(endpoints) == 0 {
+		err = fmt.Errorf("no endpoints found with AppID:%s", r.appID)
+	}
+	if err != nil {
+		r.last = nil
+		r.cc.ReportError(err)
+		return
+	}
+	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].Address < endpoints[j].Address })
+	if reflect.DeepEqual(endpoints, r.last) {
+		return
+	}
+	r.last = endpoints
+
+	addrs := make([]resolver.Address, 0, len(endpoints))
+	for _, e := range endpoints {
+		addrs = append(addrs, resolver.Address{
+			Addr:               e.Address,
+			BalancerAttributes: attributes.New(endpointKey{}, e),
+		})
+	}
+	if err := r.cc.UpdateState(resolver.State{Addresses: addrs}); err != nil {
+		log.Debugf("endpoints of %s rejected: %s", r.appID, err)
+	}
+}
+
+// ResolveEndpoints returns every endpoint of appID when d supports it, otherwise the single address
+// resolved by d.
+func ResolveEndpoints(d discovery.Discover, appID string) ([]discovery.Endpoint, error) {
+	req := nameresolution.ResolveRequest{ID: appID}
+	if er, ok := d.(discovery.EndpointResolver); ok {
+		return er.ResolveEndpoints(req)
+	}
+	address, err := d.ResolveID(req)
+	if err != nil {
+		return nil, err
+	}
+	return []discovery.Endpoint{{Address: address, Weight: 1}}, nil
+}
+
+func endpointOf(addr resolver.Address) discovery.Endpoint {
+	if e, ok := addr.BalancerAttributes.Value(endpointKey{}).(discovery.Endpoint); ok {
+		return e
+	}
+	return discovery.Endpoint{Address: addr.Addr}
+}
@@ -0,0 +1,58 @@
+package loadbalancer
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+)
+
+type versionKey struct{}
+
+// WithVersion returns a context whose calls only go to endpoints of version.
+func WithVersion(ctx context.Context, version string) context.Context {
+	return context.WithValue(ctx, versionKey{}, version)
+}
+
+func versionFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	version, _ := ctx.Value(versionKey{}).(string)
+	return version
+}
+
+// versionSplit picks a version at random in proportion to the weights.
+type versionSplit struct {
+	versions []string
+	weights  []int
+	total    int
+}
+
+func newVersionSplit(weights map[string]int) *versionSplit {
+	s := &versionSplit{}
+	for version := range weights {
+		if weights[version] > 0 {
+			s.versions = append(s.versions, version)
+		}
+	}
+	sort.Strings(s.versions)
+	for _, version := range s.versions {
+		s.weights = append(s.weights, weights[version])
+		s.total += weights[version]
+	}
+	return s
+}
+
+func (s *versionSplit) pick() string {
+	if s.total == 0 {
+		return ""
+	}
+	n := rand.Intn(s.total) //nolint:gosec
+	for i, w := range s.weights {
+		if n < w {
+			return s.versions[i]
+		}
+		n -= w
+	}
+	return ""
+}
@@ -6,23 +6,185 @@
 package log
 
 import (
+	"fmt"
+	"io"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/dapr/kit/logger"
 	"github.com/sirupsen/logrus"
 )
 
-var Log = logrus.New()
+// Correlation fields added to the log entries of a link or an invocation.
+const (
+	FieldLinkID       = "link_id"
+	FieldAppID        = "app_id"
+	FieldInvocationID = "invocation_id"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+
+	OutputStderr = "stderr"
+	OutputStdout = "stdout"
+)
+
+// Options configure every logger created with NewLogger.
+type Options struct {
+	// Level is the level of the loggers without a level in Components.
+	Level string
+	// Format is text or json.
+	Format string
+	// Output is stderr, stdout or the path of a file.
+	Output string
+	// MaxSize is the size in bytes after which a file output is rotated, 0 never rotates.
+	MaxSize int64
+	// MaxBackups is the number of rotated files kept.
+	MaxBackups int
+	// Components sets the level of loggers by name, e.g. wasmcloud.dapr.server.
+	Components map[string]string
+}
+
+// DefaultOptions logs info and above as text to stderr.
+func DefaultOptions() Options {
+	return Options{Level: "info", Format: FormatText, Output: OutputStderr}
+}
+
+var (
+	l       sync.Mutex
+	options           = DefaultOptions()
+	out     io.Writer = os.Stderr
+	loggers           = map[string]*logrus.Logger{}
+)
+
+// NewLogger returns the logger of a component, configured by the last Apply.
+func NewLogger(name string) logger.Logger {
+	l.Lock()
+	defer l.Unlock()
+	lg, ok := loggers[name]
+	if !ok {
+		lg = logrus.New()
+		configure(name, lg)
+		loggers[name] = lg
+	}
+	return &componentLogger{entry: lg.WithField("scope", name)}
+}
 
-func init() {
-	os.Setenv("RUST_LOG", "debug")
+// Apply configures every logger, the previous file output is closed once replaced.
+func Apply(opts Options) error {
+	if err := Validate(opts); err != nil {
+		return err
+	}
+	w, err := openOutput(opts)
+	if err != nil {
+		return err
+	}
+	l.Lock()
+	old := out
+	options, out = opts, w
+	for name, lg := range loggers {
+		configure(name, lg)
+	}
+	l.Unlock()
+	if c, ok := old.(io.Closer); ok && old != w {
+		_ = c.Close()
+	}
+	// the loggers of dapr libraries only support a level and a format
+	return logger.ApplyOptionsToLoggers(&logger.Options{OutputLevel: opts.Level, JSONFormatEnabled: opts.Format == FormatJSON})
+}
 
-	file, err := os.OpenFile("/tmp/httpprovider_go.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-	if err == nil {
-		Log.Out = file
+// Validate checks the levels and the format of opts.
+func Validate(opts Options) error {
+	if _, err := logrus.ParseLevel(opts.Level); err != nil {
+		return fmt.Errorf("invalid log level %q", opts.Level)
+	}
+	for name, level := range opts.Components {
+		if _, err := logrus.ParseLevel(level); err != nil {
+			return fmt.Errorf("invalid log level %q of %s", level, name)
+		}
+	}
+	if opts.Format != FormatText && opts.Format != FormatJSON {
+		return fmt.Errorf("invalid log format %q, use text or json", opts.Format)
+	}
+	return nil
+}
+
+// configure needs to be called with the lock held.
+func configure(name string, lg *logrus.Logger) {
+	level, ok := options.Components[name]
+	if !ok {
+		level = options.Level
+	}
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		lvl = logrus.InfoLevel
+	}
+	lg.SetLevel(lvl)
+	lg.SetOutput(out)
+	if options.Format == FormatJSON {
+		lg.SetFormatter(&logrus.JSONFormatter{TimestampFormat: time.RFC3339Nano})
 	} else {
-		Log.Info("Failed to log to file, using default stderr")
+		lg.SetFormatter(&logrus.TextFormatter{TimestampFormat: time.RFC3339Nano, FullTimestamp: true})
+	}
+}
+
+func openOutput(opts Options) (io.Writer, error) {
+	switch opts.Output {
+	case "", OutputStderr:
+		return os.Stderr, nil
+	case OutputStdout:
+		return os.Stdout, nil
 	}
+	l.Lock()
+	defer l.Unlock()
+	// keep the file open when only the levels or the format changed
+	if f, ok := out.(*rotatingFile); ok && f.path == opts.Output {
+		f.setLimits(opts.MaxSize, opts.MaxBackups)
+		return f, nil
+	}
+	return openRotatingFile(opts.Output, opts.MaxSize, opts.MaxBackups)
+}
+
+// componentLogger implements the dapr logger on a logrus logger shared by the loggers of a component.
+type componentLogger struct {
+	entry *logrus.Entry
+}
+
+func (c *componentLogger) EnableJSONOutput(enabled bool) {}
+
+func (c *componentLogger) SetAppID(id string) {
+	c.entry = c.entry.WithField(FieldAppID, id)
+}
+
+func (c *componentLogger) SetOutputLevel(outputLevel logger.LogLevel) {
+	if lvl, err := logrus.ParseLevel(strings.ToLower(string(outputLevel))); err == nil {
+		c.entry.Logger.SetLevel(lvl)
+	}
+}
+
+func (c *componentLogger) WithLogType(logType string) logger.Logger {
+	return &componentLogger{entry: c.entry.WithField("type", logType)}
+}
+
+func (c *componentLogger) WithFields(fields map[string]any) logger.Logger {
+	return &componentLogger{entry: c.entry.WithFields(fields)}
+}
+
+func (c *componentLogger) Info(args ...interface{})                  { c.entry.Info(args...) }
+func (c *componentLogger) Infof(format string, args ...interface{})  { c.entry.Infof(format, args...) }
+func (c *componentLogger) Debug(args ...interface{})                 { c.entry.Debug(args...) }
+func (c *componentLogger) Debugf(format string, args ...interface{}) { c.entry.Debugf(format, args...) }
+func (c *componentLogger) Warn(args ...interface{})                  { c.entry.Warn(args...) }
+func (c *componentLogger) Warnf(format string, args ...interface{})  { c.entry.Warnf(format, args...) }
+func (c *componentLogger) Error(args ...interface{})                 { c.entry.Error(args...) }
+func (c *componentLogger) Errorf(format string, args ...interface{}) { c.entry.Errorf(format, args...) }
+func (c *componentLogger) Fatal(args ...interface{})                 { c.entry.Fatal(args...) }
+func (c *componentLogger) Fatalf(format string, args ...interface{}) { c.entry.Fatalf(format, args...) }
 
-	Log.SetFormatter(&logrus.JSONFormatter{})
-	Log.SetReportCaller(true)
+// WithLink adds the correlation fields of a link to l.
+func WithLink(l logger.Logger, actorID, appID string) logger.Logger {
+	return l.WithFields(map[string]any{FieldLinkID: actorID, FieldAppID: appID})
 }
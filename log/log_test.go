@@ -0,0 +1,83 @@
+package log
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readLines(t *testing.T, path string) []map[string]interface{} {
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		lines = append(lines, entry)
+	}
+	return lines
+}
+
+func TestApply(t *testing.T) {
+	defer func() { _ = Apply(DefaultOptions()) }()
+	path := filepath.Join(t.TempDir(), "provider.log")
+	server := NewLogger("test.server")
+	resolver := NewLogger("test.resolver")
+
+	require.NoError(t, Apply(Options{
+		Level:      "info",
+		Format:     FormatJSON,
+		Output:     path,
+		Components: map[string]string{"test.resolver": "debug"},
+	}))
+	server.Debug("hidden")
+	server.Info("shown")
+	resolver.Debug("resolved")
+	WithLink(server, "MA", "a").WithFields(map[string]any{FieldInvocationID: "1"}).Warn("failed")
+
+	lines := readLines(t, path)
+	require.Len(t, lines, 3)
+	assert.Equal(t, "shown", lines[0]["msg"])
+	assert.Equal(t, "test.server", lines[0]["scope"])
+	assert.Equal(t, "resolved", lines[1]["msg"])
+	assert.Equal(t, "MA", lines[2][FieldLinkID])
+	assert.Equal(t, "a", lines[2][FieldAppID])
+	assert.Equal(t, "1", lines[2][FieldInvocationID])
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o640), info.Mode().Perm())
+
+	assert.EqualError(t, Apply(Options{Level: "verbose", Format: FormatText}), `invalid log level "verbose"`)
+	assert.EqualError(t, Apply(Options{Level: "info", Format: "xml"}), `invalid log format "xml", use text or json`)
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "provider.log")
+	f, err := openRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+	defer f.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err := f.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	read := func(name string) string {
+		b, err := os.ReadFile(name)
+		require.NoError(t, err)
+		return string(b)
+	}
+	assert.Equal(t, "fourth\n", read(path))
+	assert.Equal(t, "third\n", read(path+".1"))
+	assert.Equal(t, "second\n", read(path+".2"))
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/wasmcloud/actor-tinygo"
+	httpserver "github.com/wasmcloud/interfaces/httpserver/tinygo"
+	msgpack "github.com/wasmcloud/tinygo-msgpack"
+
+	"github.com/taction/http-provider-go/encode"
+	"github.com/taction/http-provider-go/health"
+	"github.com/taction/http-provider-go/transport"
+)
+
+const healthProbePath = "health_probe_path"
+
+// recoveryProbePath is requested from unhealthy actors of links without a health_probe_path.
+const recoveryProbePath = "/v1.0/healthz"
+
+// actorProbe sends a GET request for path to the actor, any status below 500 counts as healthy.
+func actorProbe(tp transport.Transport, path string) health.ProbeFunc {
+	return func(ctx context.Context) error {
+		body, err := encode.Encode(&httpserver.HttpRequest{Method: "GET", Path: path, Header: httpserver.HeaderMap{}})
+		if err != nil {
+			return err
+		}
+		res, err := tp.Send(actor.Message{Method: "HttpServer.HandleRequest", Arg: body})
+		if err != nil {
+			return err
+		}
+		dec := msgpack.NewDecoder(res)
+		resp, err := httpserver.MDecodeHttpResponse(&dec)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode >= 500 {
+			return fmt.Errorf("actor health probe returned status %d", resp.StatusCode)
+		}
+		return nil
+	}
+}
+
+// actorRecoveryProbe sends a GET request for recoveryProbePath to the actor, any response counts as reachable,
+// like any response to an invocation does.
+func actorRecoveryProbe(tp transport.Transport) health.ProbeFunc {
+	return func(ctx context.Context) error {
+		body, err := encode.Encode(&httpserver.HttpRequest{Method: "GET", Path: recoveryProbePath, Header: httpserver.HeaderMap{}})
+		if err != nil {
+			return err
+		}
+		_, err = tp.Send(actor.Message{Method: "HttpServer.HandleRequest", Arg: body})
+		return err
+	}
+}
+
+// reportingTransport feeds the result of every call to the actor into the link health checker.
+type reportingTransport struct {
+	transport.Transport
+	checker *health.Checker
+}
+
+func (r reportingTransport) Send(msg actor.Message) ([]byte, error) {
+	res, err := r.Transport.Send(msg)
+	// lattice connection failures are tracked by the connection monitor
+	if !errors.Is(err, transport.ErrNotConnected) && !errors.Is(err, transport.ErrQueueFull) &&
+		!errors.Is(err, transport.ErrConnectionClosed) {
+		r.checker.Report(err)
+	}
+	return res, err
+}
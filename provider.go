@@ -3,163 +3,324 @@ package main
 import (
 	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
-	"runtime"
+	"os"
+	"reflect"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/dapr/components-contrib/nameresolution"
 	invokev1 "github.com/dapr/dapr/pkg/messaging/v1"
-	"github.com/dapr/dapr/pkg/modes"
 	internalv1pb "github.com/dapr/dapr/pkg/proto/internals/v1"
-	"github.com/dapr/kit/logger"
 	provider "github.com/jordan-rash/wasmcloud-provider"
 	httpserver "github.com/wasmcloud/interfaces/httpserver/tinygo"
 	msgpack "github.com/wasmcloud/tinygo-msgpack"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/resolver"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 
+	"github.com/taction/http-provider-go/accesslog"
 	"github.com/taction/http-provider-go/discovery"
 	"github.com/taction/http-provider-go/discovery/consul"
+	"github.com/taction/http-provider-go/health"
+	"github.com/taction/http-provider-go/loadbalancer"
+	logging "github.com/taction/http-provider-go/log"
 	"github.com/taction/http-provider-go/server"
 	"github.com/taction/http-provider-go/server/daprserver"
+	httpprovider "github.com/taction/http-provider-go/server/httpserver"
 	"github.com/taction/http-provider-go/transport"
 )
 
 const (
-	// needed to load balance requests for target services with multiple endpoints, ie. multiple instances.
-	grpcServiceConfig = `{"loadBalancingPolicy":"round_robin"}`
-	dialTimeout       = time.Second * 30
-	daprAppID         = "dapr-app-id"
+	// shutdownAckTimeout is how long Run waits for the shutdown acknowledgement to be sent to the host.
+	shutdownAckTimeout = time.Second * 2
+	daprAppID          = "dapr-app-id"
+	// daprAppVersion restricts a call to the instances of a version.
+	daprAppVersion = "dapr-app-version"
 )
 
-var log = logger.NewLogger("wasmcloud.httpprovider")
+var log = logging.NewLogger("wasmcloud.httpprovider")
 
 type HttpServerProvider struct {
-	l            sync.Mutex
+	l sync.Mutex
+	// cl guards the configuration and what is built from it, which a reload replaces:
+	// config, remoteConns, ExternalHost, Resolver and endpoints.
+	cl           sync.RWMutex
+	config       ProviderConfig
+	conn         *transport.ConnectionMonitor
 	remoteConns  *RemoteConnectionPool
 	ExternalHost string
 	Actors       map[string]server.HttpServerInterface
+	links        map[string]*link
+	done         chan struct{}
+	acked        chan struct{}
+	ackOnce      sync.Once
 	Provider     provider.WasmcloudProvider
-	Resolver     discovery.Discover // todo change ResolveID to `ResolveID(req ResolveRequest) ([]string, error)`
+	Resolver     discovery.Discover
+	// endpoints resolves the dial targets of remote apps to all their instances.
+	endpoints resolver.Builder
+	newServer func(conf provider.ActorConfig, tp transport.Transport) server.HttpServerInterface
+	// rejected keeps why the last link definition of an actor was refused, reported to the host in health checks.
+	rejected map[string]error
+	// restartNeeded lists the reloaded settings which only apply after a restart.
+	restartNeeded []string
+	// admin serves the admin api, if enabled.
+	admin *http.Server
+	// draining keeps the listeners of deleted or moved links by port until they are stopped.
+	draining map[int]*drain
+	// invocations holds a slot per call of an actor in progress.
+	invocations chan struct{}
 }
-type remoteApp struct {
-	id        string
-	namespace string
-	address   string
+
+// drain is a listener serving its in-flight calls before it is stopped. A new link for the same address and
+// protocol takes it over instead of waiting for its port.
+type drain struct {
+	actorID string
+	s       server.HttpServerInterface
+	values  LinkConfig
+	// claimed is set once the listener is taken over, stopping once it can't be anymore.
+	claimed, stopping bool
+	cancel            chan struct{}
+	done              chan struct{}
+}
+
+// link keeps the provider's bookkeeping for a linked actor.
+type link struct {
+	config provider.ActorConfig
+	values LinkConfig
+	appID  string
+	// app is the registration of the link, set once registered.
+	app     discovery.App
+	checker *health.Checker
+	serving bool
 }
 
 func NewHttpServerProvider() *HttpServerProvider {
-	return &HttpServerProvider{
+	p := &HttpServerProvider{
 		Actors:      make(map[string]server.HttpServerInterface),
-		remoteConns: NewRemoteConnectionPool(),
+		links:       make(map[string]*link),
+		rejected:    make(map[string]error),
+		draining:    make(map[int]*drain),
+		invocations: make(chan struct{}, maxActorInvocations),
+		done:        make(chan struct{}),
+		acked:       make(chan struct{}),
+		remoteConns: NewRemoteConnectionPool(defaultMaxConnIdle, 0),
+	}
+	p.newServer = func(conf provider.ActorConfig, tp transport.Transport) server.HttpServerInterface {
+		if conf.ActorConfig[linkProtocol] == discovery.ProtocolHTTP {
+			// the http listener also serves the dapr invoke api for other apps
+			return httpprovider.New(conf, tp, p.invokeRemote)
+		}
+		return daprserver.New(conf, tp)
 	}
+	return p
 }
 
 func (p *HttpServerProvider) Run() (err error) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	defer func() {
-		log.Infof("run error %s \n", err.Error())
+		if err != nil {
+			log.Infof("run error %s \n", err.Error())
+		}
 	}()
 
-	p.Provider, err = provider.Init(ctx)
+	p.Provider, err = initProvider(os.Stdin)
 	if err != nil {
 		return err
 	}
 	log.Infof("provider init success, host data: %+v\n", p.Provider.HostData)
+	p.config, err = loadConfig(p.Provider.HostData.ConfigJson)
+	if err != nil {
+		return err
+	}
+	err = p.config.Validate()
+	if err != nil {
+		return err
+	}
+	err = p.config.Log.apply()
+	if err != nil {
+		return err
+	}
+	p.config.AccessLog.apply()
+	p.config.Invocation.Headers.apply()
+	p.remoteConns = NewRemoteConnectionPool(p.config.Remote.MaxConnIdle.Duration, p.config.Remote.MinActiveConns)
+	p.conn = transport.NewConnectionMonitor(p.Provider.NatsConnection, transport.QueueOptions{
+		Size:    p.config.ReconnectQueueSize,
+		Timeout: p.config.ReconnectQueueTimeout.Duration,
+	})
+	p.conn.OnStateChange(p.onConnectionStateChange)
 	err = p.initDiscovery()
 	if err != nil {
 		return err
 	}
+	err = p.watchConfig(ctx)
+	if err != nil {
+		return err
+	}
+	err = p.startAdmin()
+	if err != nil {
+		return err
+	}
 
-	// Listen for Shutdown request
-	go func() {
-		<-p.Provider.Shutdown
-		close(p.Provider.ProviderAction)
-		close(p.Provider.Links)
-		cancel()
-	}()
+	err = p.subscribeHost(p.Provider.NatsConnection)
+	if err != nil {
+		return err
+	}
 
 	// Start listening on topic for requests from actor
-	p.Provider.ListenForActor("")
+	err = p.subscribeActors(p.Provider.NatsConnection)
+	if err != nil {
+		return err
+	}
 
+	// Listen for Shutdown request
 	go func() {
-		//Wait for valid requests
-		for actorRequest := range p.Provider.ProviderAction {
-			p.evaluateRequest(actorRequest)
-		}
+		<-p.Provider.Shutdown
+		p.Shutdown()
+		close(p.done)
+		cancel()
 	}()
 
 	// Wait for a valid link definiation
 	log.Debug("Ready for link definitions")
 	// put link
-	for actorData := range p.Provider.Links {
-
-		// Configure and start the server
-		//lsConfig := validateConfig(actorData.ActorConfig)
-		err := p.PutLink(actorData)
-		if err != nil {
-			log.Error(err)
+	for running := true; running; {
+		select {
+		case actorData := <-p.Provider.Links:
+			err := p.PutLink(actorData)
+			if err != nil {
+				log.Error(err)
+			}
+		case <-p.done:
+			running = false
 		}
 	}
-	return nil
+	// give the host the acknowledgement of the shutdown before exiting
+	select {
+	case <-p.acked:
+	case <-time.After(shutdownAckTimeout):
+	}
+	return p.Provider.NatsConnection.Drain()
 }
 
 func (p *HttpServerProvider) initDiscovery() (err error) {
-	c := ProviderConfig{}
-	err = json.Unmarshal([]byte(p.Provider.HostData.ConfigJson), &c)
-	if err != nil {
-		return err
-	}
-	log.Debugf("init discovery with config: %+v\n", c)
-	resolver := consul.NewResolver(log)
-	err = resolver.Init(nameresolution.Metadata{
-		Configuration: consul.IntermediateConfig{Client: &consul.Config{Address: c.ResolverAddress}, DaprPortMetaKey: nameresolution.DaprPort},
-	})
+	c := p.config
+	resolver, err := newDiscovery(c)
 	if err != nil {
 		return err
 	}
 	p.Resolver = resolver
+	p.endpoints = loadbalancer.NewResolverBuilder(resolver, c.LoadBalancing.RefreshInterval.Duration)
 	p.ExternalHost = c.ExternalAddress
 	return nil
 }
 
-// send request to outside
-func (p *HttpServerProvider) evaluateRequest(actorRequest provider.ProviderAction) {
-	log.Debugf("receive actor request operation: %s\n", actorRequest.Operation)
-	resp := provider.ProviderResponse{}
-	buf, err := p.daprRequest(actorRequest)
+// newDiscovery creates and initialises the name resolution of c.
+func newDiscovery(c ProviderConfig) (discovery.Discover, error) {
+	log.Debugf("init discovery %s", c.NameResolution.Component)
+	resolver, err := discovery.Create(c.NameResolution.Component, logging.NewLogger("wasmcloud.discovery"))
+	if err != nil {
+		return nil, err
+	}
+	configuration := c.NameResolution.Configuration
+	if configuration == nil && c.NameResolution.Component == defaultNameResolution {
+		configuration = consul.IntermediateConfig{Client: &consul.Config{Address: c.ResolverAddress}, DaprPortMetaKey: nameresolution.DaprPort}
+	}
+	err = resolver.Init(nameresolution.Metadata{Configuration: configuration})
+	if err != nil {
+		return nil, err
+	}
+	return resolver, nil
+}
+
+// settings returns the current configuration.
+func (p *HttpServerProvider) settings() ProviderConfig {
+	p.cl.RLock()
+	defer p.cl.RUnlock()
+	return p.config
+}
+
+// nameResolver returns the current name resolution.
+func (p *HttpServerProvider) nameResolver() discovery.Discover {
+	p.cl.RLock()
+	defer p.cl.RUnlock()
+	return p.Resolver
+}
+
+// onConnectionStateChange marks every link unhealthy while the lattice is unreachable,
+// so that discovery stops routing calls to us.
+func (p *HttpServerProvider) onConnectionStateChange(state transport.ConnectionState) {
+	log.Infof("lattice connection %s", state)
+	p.l.Lock()
+	defer p.l.Unlock()
+	for actorID := range p.Actors {
+		p.updateServing(actorID)
+	}
+}
+
+// updateServing reports a link as serving only when both the lattice connection and the actor are healthy.
+// Needs to be called with the lock held.
+func (p *HttpServerProvider) updateServing(actorID string) {
+	s, ok := p.Actors[actorID]
+	if !ok {
+		return
+	}
+	serving := p.conn.State() == transport.Connected
+	l, ok := p.links[actorID]
+	if ok {
+		serving = serving && l.checker.Healthy()
+		l.serving = serving
+		if hr, ok := p.nameResolver().(discovery.HealthReporter); ok && l.app.AppID != "" {
+			hr.SetHealth(l.app, serving)
+		}
+	}
+	s.SetServing(serving)
+}
+
+// send request to outside, within the call timeout of the remote configuration
+func (p *HttpServerProvider) evaluateRequest(inv provider.Invocation) provider.InvocationResponse {
+	log.Debugf("receive actor request operation: %s\n", inv.Operation)
+	resp := provider.InvocationResponse{InvocationID: inv.ID, InstanceID: inv.HostID}
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if timeout := p.settings().Remote.CallTimeout.Duration; timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
+	buf, err := p.daprRequest(ctx, inv.Origin.PublicKey, inv.Operation, inv.Msg)
 	if err != nil {
 		resp.Error = err.Error()
 	} else {
 		resp.Msg = buf
 	}
-	// Send response
-	actorRequest.Respond <- resp
+	return resp
 }
 
-func (p *HttpServerProvider) daprRequest(actorRequest provider.ProviderAction) ([]byte, error) {
-	operationL := strings.Split(actorRequest.Operation, ".")
+func (p *HttpServerProvider) daprRequest(ctx context.Context, actorID, operation string, msg []byte) ([]byte, error) {
+	operationL := strings.Split(operation, ".")
 	switch operationL[len(operationL)-1] {
 	case "HandleRequest":
 		// Decode the request from actor
-		dec := msgpack.NewDecoder(actorRequest.Msg)
+		dec := msgpack.NewDecoder(msg)
 		req, err := httpserver.MDecodeHttpRequest(&dec)
 		if err != nil {
 			log.Warnf("Receive actor request decode err: %s", err)
 			return nil, err
 		}
-		pres, err := p.callDaprRemote(context.TODO(), req)
+		appID, err := p.routeRequest(actorID, &req)
+		if err != nil {
+			return nil, err
+		}
+		pres, err := p.invokeApp(ctx, appID, req)
 		if err != nil {
 			log.Warnf("Receive actor request decode call dapr remote err: %s", err)
 			return nil, err
@@ -173,13 +334,80 @@ func (p *HttpServerProvider) daprRequest(actorRequest provider.ProviderAction) (
 		pres.MEncode(enc)
 		return buf, nil
 	default:
-		log.Errorf("unknown operation: %s\n", actorRequest.Operation)
+		log.Errorf("unknown operation: %s\n", operation)
 		return nil, errors.New("Invalid Operation")
 	}
 }
 
-func (g *HttpServerProvider) callDaprRemote(ctx context.Context, r httpserver.HttpRequest) (*httpserver.HttpResponse, error) {
-	log.Debugf("actor call provider req: %+v\n", r)
+// routeRequest returns the app a call of actorID goes to: the one of its dapr-app-id header, else the one
+// the routes or the default target of the link of the actor give, and removes the headers the link strips.
+func (p *HttpServerProvider) routeRequest(actorID string, r *httpserver.HttpRequest) (string, error) {
+	p.l.Lock()
+	var values LinkConfig
+	if l, ok := p.links[actorID]; ok {
+		values = l.values
+	}
+	p.l.Unlock()
+	appID := headerValue(r.Header, daprAppID)
+	if appID == "" {
+		appID = values.target(r.Path)
+	}
+	if appID == "" {
+		return "", fmt.Errorf("dapr-app-id not found, set the header or the %s or %s values of the link", linkDefaultTarget, linkRoutes)
+	}
+	for _, name := range values.StripHeaders {
+		removeHeader(r.Header, name)
+	}
+	server.SetCaller(r.Header, values.AppID)
+	return appID, nil
+}
+
+// invokeRemote calls the app named by the dapr-app-id header of r.
+func (p *HttpServerProvider) invokeRemote(ctx context.Context, r httpserver.HttpRequest) (*httpserver.HttpResponse, error) {
+	appID := headerValue(r.Header, daprAppID)
+	if appID == "" {
+		return nil, errors.New("dapr-app-id not found")
+	}
+	return p.invokeApp(ctx, appID, r)
+}
+
+// invokeApp calls appID with r.
+func (p *HttpServerProvider) invokeApp(ctx context.Context, appID string, r httpserver.HttpRequest) (*httpserver.HttpResponse, error) {
+	if ns := p.settings().Invocation.Namespace; ns != "" && headerValue(r.Header, server.CallerAppIDHeader) != "" {
+		r.Header[server.CallerNamespaceHeader] = httpserver.HeaderValues{ns}
+	}
+	start := time.Now()
+	resp, err := p.callDaprRemote(ctx, appID, r)
+	if accesslog.Enabled() {
+		logOutbound(appID, r, resp, time.Since(start), err)
+	}
+	return resp, err
+}
+
+// logOutbound writes the access log entry of a call of an actor to a remote app.
+func logOutbound(appID string, req httpserver.HttpRequest, resp *httpserver.HttpResponse, latency time.Duration, err error) {
+	headers := accesslog.FromHeaderMap(req.Header)
+	e := accesslog.Entry{
+		Direction:    accesslog.Outbound,
+		Protocol:     "grpc",
+		TargetAppID:  appID,
+		Method:       req.Method,
+		Path:         req.Path,
+		RequestBytes: len(req.Body),
+		TraceID:      accesslog.TraceID(headers),
+		Headers:      headers,
+		RequestBody:  req.Body,
+		Err:          err,
+	}
+	if err != nil {
+		e.Status = http.StatusInternalServerError
+	} else {
+		e.Status, e.ResponseBytes, e.ResponseBody = int(resp.StatusCode), len(resp.Body), resp.Body
+	}
+	accesslog.Log(e)
+}
+
+func (g *HttpServerProvider) callDaprRemote(ctx context.Context, appId string, r httpserver.HttpRequest) (*httpserver.HttpResponse, error) {
 	// todo check why header has empty string
 	mh := metadata.MD{}
 	if len(r.Header) > 0 {
@@ -191,12 +419,6 @@ func (g *HttpServerProvider) callDaprRemote(ctx context.Context, r httpserver.Ht
 			}
 		}
 	}
-	appId := ""
-	if appIDs := mh[daprAppID]; len([]string(appIDs)) == 0 {
-		return nil, errors.New("dapr-app-id not found")
-	} else {
-		appId = appIDs[0]
-	}
 	contentType := ""
 	if len(mh) > 0 && len(mh["content-type"]) > 0 {
 		contentType = r.Header["content-type"][0]
@@ -210,27 +432,74 @@ func (g *HttpServerProvider) callDaprRemote(ctx context.Context, r httpserver.Ht
 	// Save headers to internal metadata
 	req.WithMetadata(mh)
 
-	a, err := g.getRemoteApp(appId)
-	conn, teardown, err := g.GetGRPCConnection(context.TODO(), a.address)
+	var version string
+	if versions := mh.Get(daprAppVersion); len(versions) > 0 {
+		version = versions[0]
+	}
+	maxSize := g.settings().Remote.MaxMessageSize
+	if target := g.localTarget(appId, version); target != nil {
+		// the actor is linked to this provider, skip discovery, the network and the lattice round trip
+		// through our own listener. The request is the one the listener would have received.
+		in := req.Proto()
+		if maxSize > 0 && proto.Size(in) > maxSize {
+			return nil, status.Errorf(codes.ResourceExhausted, "trying to send message larger than max (%d vs. %d)", proto.Size(in), maxSize)
+		}
+		response, err := target.CallLocal(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+		return toHttpResponse(response)
+	}
+
+	conn, teardown, err := g.GetGRPCConnection(ctx, appId)
 	if err != nil {
 		log.Warnf("Call dapr remote get conn err: %s", err)
-		code := status.Code(err)
-		if code == codes.Unavailable || code == codes.Unauthenticated {
-			// Destroy the connection and force a re-connection on the next attempt
-			teardown(true)
-		}
-		teardown(false)
 		return nil, err
 	}
-	teardown(false)
+	defer teardown(false)
 	clientV1 := internalv1pb.NewServiceInvocationClient(conn)
 	var opts []grpc.CallOption
-	opts = append(opts, grpc.MaxCallRecvMsgSize(4*1024*1024), grpc.MaxCallSendMsgSize(4*1024*1024))
+	if maxSize > 0 {
+		opts = append(opts, grpc.MaxCallRecvMsgSize(maxSize), grpc.MaxCallSendMsgSize(maxSize))
+	}
 
+	if version != "" {
+		ctx = loadbalancer.WithVersion(ctx, version)
+	}
 	response, err := clientV1.CallLocal(ctx, req.Proto(), opts...)
 	if err != nil {
 		return nil, err
 	}
+	return toHttpResponse(response)
+}
+
+// localTarget returns the listener of a serving grpc link registered as appID, and version if set,
+// so that calls between actors of this provider stay in the process. Calls without a version for an app
+// with a version split go through the balancer, which picks the version.
+func (p *HttpServerProvider) localTarget(appID, version string) internalv1pb.ServiceInvocationServer {
+	if version == "" && len(p.settings().LoadBalancing.VersionSplit[appID]) > 0 {
+		return nil
+	}
+	p.l.Lock()
+	defer p.l.Unlock()
+	var target internalv1pb.ServiceInvocationServer
+	var targetID string
+	for actorID, l := range p.links {
+		v := l.values
+		if v.AppID != appID || v.Protocol != discovery.ProtocolGRPC || !l.serving || (version != "" && v.Version != version) {
+			continue
+		}
+		s, ok := p.Actors[actorID].(internalv1pb.ServiceInvocationServer)
+		// prefer the same actor for every call when several links serve the app
+		if ok && (target == nil || actorID < targetID) {
+			target, targetID = s, actorID
+		}
+	}
+	return target
+}
+
+// toHttpResponse converts the response of a dapr app to the response of an actor call.
+func toHttpResponse(response *internalv1pb.InternalInvokeResponse) (*httpserver.HttpResponse, error) {
 	resp, err := invokev1.InternalInvokeResponse(response)
 	if err != nil {
 		return nil, err
@@ -252,55 +521,72 @@ func (g *HttpServerProvider) callDaprRemote(ctx context.Context, r httpserver.Ht
 	res.StatusCode = uint16(statusCode)
 	return &res, nil
 }
-func nopTeardown(destroy bool) {
-	// Nop
-}
 
-func (d *HttpServerProvider) getRemoteApp(appID string) (remoteApp, error) {
-	//id, namespace, err := d.requestAppIDAndNamespace(appID)
-	//if err != nil {
-	//	return remoteApp{}, err
-	//}
+// headerValue returns the first value of the header name of h, whatever its case.
+func headerValue(h httpserver.HeaderMap, name string) string {
+	for k, v := range h {
+		if strings.EqualFold(k, name) && len(v) > 0 && v[0] != "" {
+			return v[0]
+		}
+	}
+	return ""
+}
 
-	request := nameresolution.ResolveRequest{ID: appID}
-	address, err := d.Resolver.ResolveID(request)
-	if err != nil {
-		return remoteApp{}, err
+// removeHeader removes the header name from h, whatever its case.
+func removeHeader(h httpserver.HeaderMap, name string) {
+	for k := range h {
+		if strings.EqualFold(k, name) {
+			delete(h, k)
+		}
 	}
+}
 
-	return remoteApp{
-		id:      appID,
-		address: address,
-	}, nil
+func nopTeardown(destroy bool) {
+	// Nop
 }
 
-func (g *HttpServerProvider) GetGRPCConnection(parentCtx context.Context, address string, customOpts ...grpc.DialOption) (conn *grpc.ClientConn, teardown func(destroy bool), err error) {
+// GetGRPCConnection returns a connection balanced over every instance of appID.
+func (g *HttpServerProvider) GetGRPCConnection(parentCtx context.Context, appID string, customOpts ...grpc.DialOption) (conn *grpc.ClientConn, teardown func(destroy bool), err error) {
+	g.cl.RLock()
+	pool, endpoints, c := g.remoteConns, g.endpoints, g.config
+	g.cl.RUnlock()
 	// Load or create a connection
 	var connI grpc.ClientConnInterface
-	connI, err = g.remoteConns.Get(address, func() (grpc.ClientConnInterface, error) {
-		log.Infof("Creating new remote conn to address: %s", address)
-		return g.connectRemote(parentCtx, address, customOpts...)
+	connI, err = pool.Get(appID, func() (grpc.ClientConnInterface, error) {
+		log.Infof("Creating new remote conn to app: %s", appID)
+		return connectRemote(parentCtx, c, endpoints, appID, customOpts...)
 	})
 	if err != nil {
-		log.Errorf("Creating new remote conn to address: %s failed %s", address, err)
+		log.Errorf("Creating new remote conn to app: %s failed %s", appID, err)
 		return nil, nopTeardown, err
 	}
 	conn = connI.(*grpc.ClientConn)
-	return conn, g.connTeardownFactory(address, conn), nil
+	return conn, connTeardownFactory(pool, appID, conn), nil
 }
 
-func (g *HttpServerProvider) connectRemote(
+func connectRemote(
 	parentCtx context.Context,
-	address string,
+	c ProviderConfig,
+	endpoints resolver.Builder,
+	appID string,
 	customOpts ...grpc.DialOption,
 ) (conn *grpc.ClientConn, err error) {
+	creds, err := c.Remote.TLS.credentials()
+	if err != nil {
+		return nil, err
+	}
 	opts := []grpc.DialOption{
-		grpc.WithDefaultServiceConfig(grpcServiceConfig),
-		grpc.WithTransportCredentials(insecure.NewCredentials()), // todo fix tls or mtls usage
+		grpc.WithResolvers(endpoints),
+		grpc.WithDefaultServiceConfig(loadbalancer.ServiceConfig(c.LoadBalancing.options(appID))),
+		grpc.WithTransportCredentials(creds),
+	}
+	opts = append(opts, customOpts...)
+	ctx := parentCtx
+	cancel := func() {}
+	if timeout := c.Remote.DialTimeout.Duration; timeout > 0 {
+		ctx, cancel = context.WithTimeout(parentCtx, timeout)
 	}
-	dialPrefix := GetDialAddressPrefix(modes.StandaloneMode) // todo define in config
-	ctx, cancel := context.WithTimeout(parentCtx, dialTimeout)
-	conn, err = grpc.DialContext(ctx, dialPrefix+address, opts...)
+	conn, err = grpc.DialContext(ctx, loadbalancer.Target(appID), opts...)
 	cancel()
 	if err != nil {
 		return nil, err
@@ -309,31 +595,17 @@ func (g *HttpServerProvider) connectRemote(
 	return conn, nil
 }
 
-func (g *HttpServerProvider) connTeardownFactory(address string, conn *grpc.ClientConn) func(destroy bool) {
+// connTeardownFactory returns the conn to the pool it was taken from, which a reload may have replaced since.
+func connTeardownFactory(pool *RemoteConnectionPool, address string, conn *grpc.ClientConn) func(destroy bool) {
 	return func(destroy bool) {
 		if destroy {
-			g.remoteConns.Destroy(address, conn)
+			pool.Destroy(address, conn)
 		} else {
-			g.remoteConns.Release(address, conn)
+			pool.Release(address, conn)
 		}
 	}
 }
 
-// GetDialAddressPrefix returns a dial prefix for a gRPC client connections
-// For a given DaprMode.
-func GetDialAddressPrefix(mode modes.DaprMode) string {
-	if runtime.GOOS == "windows" {
-		return ""
-	}
-
-	switch mode {
-	case modes.KubernetesMode:
-		return "dns:///"
-	default:
-		return ""
-	}
-}
-
 func doRequest(actorRequest provider.ProviderAction) ([]byte, error) {
 	// Decode the request from actor
 	decoder := msgpack.NewDecoder(actorRequest.Msg)
@@ -386,46 +658,367 @@ func transferToResponse(res *http.Response) (r *httpserver.HttpResponse, e error
 
 // ----------------------------------------
 
+// PutLink starts serving the actor of the link definition. A link which is already running is updated
+// in place, or moved to a new listener when its address changes.
 func (p *HttpServerProvider) PutLink(l provider.LinkDefinition) error {
-	tr := transport.NewTransport(l, p.Provider.NatsConnection, p.Provider.HostData)
-	c := l.ToActorConfig()
-	server := daprserver.New(c, tr)
-	err := server.Run()
+	err := p.putLink(l)
+	p.l.Lock()
 	if err != nil {
-		return err
+		err = fmt.Errorf("link for actor %s rejected: %w", l.ActorID, err)
+		p.rejected[l.ActorID] = err
+	} else {
+		delete(p.rejected, l.ActorID)
 	}
-	_, port, err := net.SplitHostPort(c.ActorConfig["address"])
+	p.l.Unlock()
+	return err
+}
+
+func (p *HttpServerProvider) putLink(l provider.LinkDefinition) error {
+	lc, err := p.checkLink(l)
 	if err != nil {
 		return err
 	}
-	// todo  change address to host:port
-	err = p.Resolver.RegisterToDiscovery(discovery.App{AppID: server.UniqueID, Address: fmt.Sprintf("%s:%s", p.ExternalHost, port)}) //nolint
+	p.l.Lock()
+	running, ok := p.Actors[l.ActorID]
+	current := p.links[l.ActorID]
+	p.l.Unlock()
+	if ok && current != nil {
+		return p.updateLink(l, lc, running, current)
+	}
+	if s := p.claimDrain(lc); s != nil {
+		return p.resumeLink(l, lc, s)
+	}
+
+	s, nl, err := p.startLink(l, lc)
 	if err != nil {
 		return err
 	}
+	p.storeLink(l.ActorID, s, nl)
+	return nil
+}
+
+// claimDrain takes over the draining listener on the port of lc when it has the same address and protocol.
+// Otherwise it waits until the listener holding the port is stopped.
+func (p *HttpServerProvider) claimDrain(lc LinkConfig) server.HttpServerInterface {
 	p.l.Lock()
-	p.Actors[c.ActorID] = server
+	d, ok := p.draining[lc.Port]
+	if !ok {
+		p.l.Unlock()
+		return nil
+	}
+	if !d.stopping && d.values.Address == lc.Address && d.values.Protocol == lc.Protocol {
+		d.claimed = true
+		delete(p.draining, lc.Port)
+		close(d.cancel)
+		p.l.Unlock()
+		return d.s
+	}
 	p.l.Unlock()
+	log.Infof("waiting for the listener of actor %s to stop before using port %d", d.actorID, lc.Port)
+	<-d.done
 	return nil
 }
 
-func (p *HttpServerProvider) DeleteLink(actorID string) {
+// resumeLink serves the link l with the listener s taken over from a deleted link.
+func (p *HttpServerProvider) resumeLink(l provider.LinkDefinition, lc LinkConfig, s server.HttpServerInterface) error {
+	tr, nl := p.newLink(l, lc)
+	s.Update(nl.config, tr)
+	if err := p.register(nl); err != nil {
+		nl.checker.Stop()
+		ctx, cancel := context.WithTimeout(context.Background(), p.settings().ShutdownTimeout.Duration)
+		defer cancel()
+		_ = s.Shutdown(ctx)
+		return err
+	}
+	p.storeLink(l.ActorID, s, nl)
+	log.Infof("link for actor %s took over the listener on %s", l.ActorID, lc.Address)
+	return nil
+}
+
+// checkLink parses the values of l and makes sure its port is not used by the link of another actor.
+func (p *HttpServerProvider) checkLink(l provider.LinkDefinition) (LinkConfig, error) {
+	lc, err := parseLinkConfig(l.Values)
+	if err != nil {
+		return lc, err
+	}
+	p.l.Lock()
+	defer p.l.Unlock()
+	for actorID, other := range p.links {
+		if actorID != l.ActorID && other.values.Port == lc.Port {
+			return lc, fmt.Errorf("port %d of address %q is already used by the link of actor %s", lc.Port, lc.Address, actorID)
+		}
+	}
+	return lc, nil
+}
+
+// updateLink applies a changed link definition without dropping traffic: the new listener or
+// registration is in place before the old one is removed. A new listener on the port of the old one
+// can only be started once the old one is stopped.
+func (p *HttpServerProvider) updateLink(l provider.LinkDefinition, lc LinkConfig, running server.HttpServerInterface, current *link) error {
+	c := l.ToActorConfig()
+	if reflect.DeepEqual(current.config.ActorConfig, c.ActorConfig) {
+		log.Debugf("link for actor %s is unchanged", c.ActorID)
+		return nil
+	}
+
+	if lc.Address != current.values.Address || lc.Protocol != current.values.Protocol {
+		if lc.Port == current.values.Port {
+			return p.restartLink(l, lc, running, current)
+		}
+		s, nl, err := p.startLink(l, lc)
+		if err != nil {
+			return err
+		}
+		p.l.Lock()
+		d := p.drainLocked(c.ActorID, running, current)
+		p.l.Unlock()
+		p.storeLink(c.ActorID, s, nl)
+		log.Infof("link for actor %s moved to %s", c.ActorID, lc.Address)
+		go func() {
+			// the registration is shared when the external address did not change
+			_ = p.teardownLink(c.ActorID, running, current, d, current.app.InstanceID() != nl.app.InstanceID())
+		}()
+		return nil
+	}
+
+	// same address, swap the configuration of the running listener
+	tr, nl := p.newLink(l, lc)
+	nl.app = current.app
+	// a link deregistered through the admin api is registered again
+	if current.app.AppID == "" || nl.appID != current.appID || lc.Version != current.app.Version {
+		if err := p.register(nl); err != nil {
+			nl.checker.Stop()
+			return err
+		}
+	}
+	running.Update(c, tr)
+	current.checker.Stop()
+	p.storeLink(c.ActorID, running, nl)
+	if current.app.AppID != "" && nl.app.InstanceID() != current.app.InstanceID() {
+		p.nameResolver().RemoveFromDiscovery(current.app)
+	}
+	log.Infof("link for actor %s updated", c.ActorID)
+	return nil
+}
+
+// restartLink replaces the listener of current by a new one on the same port, the old one is deregistered and
+// drained first since the port can't be bound before it is closed.
+func (p *HttpServerProvider) restartLink(l provider.LinkDefinition, lc LinkConfig, running server.HttpServerInterface, current *link) error {
+	actorID := l.ActorID
 	p.l.Lock()
-	s := p.Actors[actorID]
 	delete(p.Actors, actorID)
+	delete(p.links, actorID)
+	d := p.drainLocked(actorID, running, current)
+	// a link put meanwhile on the port waits for the listener to stop instead of taking it over
+	d.stopping = true
 	p.l.Unlock()
-	go func() {
-		p.Resolver.RemoveFromDiscovery(actorID)
-		s.Shutdown()
-	}()
+	log.Infof("restarting the listener of actor %s on %s", actorID, lc.Address)
+	_ = p.teardownLink(actorID, running, current, d, true)
+
+	s, nl, err := p.startLink(l, lc)
+	if err != nil {
+		return err
+	}
+	p.storeLink(actorID, s, nl)
+	return nil
+}
+
+// newLink builds the transport to the actor and the health bookkeeping of a link.
+func (p *HttpServerProvider) newLink(l provider.LinkDefinition, lc LinkConfig) (transport.Transport, *link) {
+	c := l.ToActorConfig()
+	tr := transport.NewTransport(l, p.conn, p.Provider.HostData)
+	checker := p.newChecker(c.ActorID, lc, tr)
+	return reportingTransport{Transport: tr, checker: checker}, &link{config: c, values: lc, appID: lc.AppID, checker: checker}
+}
+
+// newChecker builds the health checker of the link of actorID. Without a health_probe_path the health follows
+// the invocations, and unhealthy actors are probed until they answer again.
+func (p *HttpServerProvider) newChecker(actorID string, lc LinkConfig, tr transport.Transport) *health.Checker {
+	onChange := func(healthy bool) {
+		log.Infof("actor %s health changed, healthy: %t", actorID, healthy)
+		p.l.Lock()
+		p.updateServing(actorID)
+		p.l.Unlock()
+	}
+	if lc.HealthProbePath != "" {
+		return health.NewChecker(p.settings().Health.options(), actorProbe(tr, lc.HealthProbePath), onChange)
+	}
+	return health.NewPassiveChecker(p.settings().Health.options(), actorRecoveryProbe(tr), onChange)
+}
+
+// startLink runs a new listener for the link and registers it.
+func (p *HttpServerProvider) startLink(l provider.LinkDefinition, lc LinkConfig) (server.HttpServerInterface, *link, error) {
+	tr, nl := p.newLink(l, lc)
+	s := p.newServer(nl.config, tr)
+	err := s.Run()
+	if err != nil {
+		nl.checker.Stop()
+		return nil, nil, err
+	}
+	err = p.register(nl)
+	if err != nil {
+		nl.checker.Stop()
+		_ = s.Shutdown(context.Background())
+		return nil, nil, err
+	}
+	return s, nl, nil
+}
+
+// register announces the listener of l at the external host.
+func (p *HttpServerProvider) register(l *link) error {
+	p.cl.RLock()
+	externalHost, resolver := p.ExternalHost, p.Resolver
+	p.cl.RUnlock()
+	app, err := p.registerApp(resolver, externalHost, l)
+	if err != nil {
+		return err
+	}
+	l.app = app
+	return nil
+}
+
+// registerApp announces the listener of l at externalHost in resolver.
+func (p *HttpServerProvider) registerApp(resolver discovery.Discover, externalHost string, l *link) (discovery.App, error) {
+	app := discovery.App{
+		AppID:    l.appID,
+		Address:  net.JoinHostPort(externalHost, strconv.Itoa(l.values.Port)),
+		Version:  l.values.Version,
+		Host:     p.Provider.HostData.HostID,
+		Protocol: l.values.Protocol,
+	}
+	return app, resolver.RegisterToDiscovery(app)
+}
+
+func (p *HttpServerProvider) storeLink(actorID string, s server.HttpServerInterface, l *link) {
+	p.l.Lock()
+	p.Actors[actorID] = s
+	p.links[actorID] = l
+	p.updateServing(actorID)
+	p.l.Unlock()
+	l.checker.Start()
+}
+
+// DeleteLink removes the link of actorID: the listener is marked unhealthy and deregistered, then it keeps
+// serving in-flight calls for the drain period before being stopped. Unknown actors are ignored.
+func (p *HttpServerProvider) DeleteLink(actorID string) error {
+	p.l.Lock()
+	s, ok := p.Actors[actorID]
+	l := p.links[actorID]
+	delete(p.Actors, actorID)
+	delete(p.links, actorID)
+	delete(p.rejected, actorID)
+	var d *drain
+	if ok {
+		d = p.drainLocked(actorID, s, l)
+	}
+	p.l.Unlock()
+	if !ok {
+		log.Debugf("delete link for actor %s which is not linked", actorID)
+		return nil
+	}
+	return p.teardownLink(actorID, s, l, d, true)
 }
 
+// Shutdown stops taking new calls, deregisters every app, drains the listeners and closes remote connections.
 func (p *HttpServerProvider) Shutdown() {
 	p.l.Lock()
-	for _, s := range p.Actors {
-		s.Shutdown()
+	actors := p.Actors
+	links := p.links
+	p.Actors = make(map[string]server.HttpServerInterface)
+	p.links = make(map[string]*link)
+	drains := make(map[string]*drain, len(actors))
+	for actorID, s := range actors {
+		drains[actorID] = p.drainLocked(actorID, s, links[actorID])
+	}
+	p.l.Unlock()
+
+	var wg sync.WaitGroup
+	for actorID, s := range actors {
+		wg.Add(1)
+		go func(actorID string, s server.HttpServerInterface) {
+			defer wg.Done()
+			_ = p.teardownLink(actorID, s, links[actorID], drains[actorID], true)
+		}(actorID, s)
+	}
+	wg.Wait()
+	p.stopAdmin()
+	p.cl.RLock()
+	pool, resolver := p.remoteConns, p.Resolver
+	p.cl.RUnlock()
+	pool.DestroyAll()
+	if c, ok := resolver.(io.Closer); ok {
+		_ = c.Close()
+	}
+}
+
+// teardownLink stops a listener which has already been removed from Actors, unless a new link
+// takes it over while it drains as d.
+func (p *HttpServerProvider) teardownLink(actorID string, s server.HttpServerInterface, l *link, d *drain, deregister bool) error {
+	s.SetServing(false)
+	var claimed <-chan struct{}
+	if l != nil {
+		l.checker.Stop()
+		if deregister && l.app.AppID != "" {
+			p.nameResolver().RemoveFromDiscovery(l.app)
+		}
+	}
+	if d != nil {
+		claimed = d.cancel
+		defer p.endDrain(d)
+	}
+	c := p.settings()
+	// give discovery and the callers' health checks time to stop routing to us
+	timer := time.NewTimer(c.DrainPeriod.Duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-claimed:
+	}
+	if d != nil && !p.stopDrain(d) {
+		log.Infof("server for actor %s taken over by a new link", actorID)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.ShutdownTimeout.Duration)
+	defer cancel()
+	err := s.Shutdown(ctx)
+	if err != nil {
+		log.Warnf("server for actor %s did not stop in time, in-flight calls were closed: %s", actorID, err)
+		return err
+	}
+	log.Infof("server for actor %s stopped", actorID)
+	return nil
+}
+
+// drainLocked keeps the port of the listener s of the link l until it is stopped, it must be called with p.l held.
+func (p *HttpServerProvider) drainLocked(actorID string, s server.HttpServerInterface, l *link) *drain {
+	if l == nil {
+		return nil
+	}
+	d := &drain{actorID: actorID, s: s, values: l.values, cancel: make(chan struct{}), done: make(chan struct{})}
+	p.draining[l.values.Port] = d
+	return d
+}
+
+// stopDrain marks d as stopping, it returns false if the listener was taken over.
+func (p *HttpServerProvider) stopDrain(d *drain) bool {
+	p.l.Lock()
+	defer p.l.Unlock()
+	if d.claimed {
+		return false
+	}
+	d.stopping = true
+	return true
+}
+
+// endDrain releases the port of d once its listener is stopped or taken over.
+func (p *HttpServerProvider) endDrain(d *drain) {
+	p.l.Lock()
+	if p.draining[d.values.Port] == d {
+		delete(p.draining, d.values.Port)
 	}
 	p.l.Unlock()
+	close(d.done)
 }
 
 func transferToHttpRequest(r *httpserver.HttpRequest) (*http.Request, error) {
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dapr/components-contrib/nameresolution"
+	invokev1 "github.com/dapr/dapr/pkg/messaging/v1"
+	internalv1pb "github.com/dapr/dapr/pkg/proto/internals/v1"
+	provider "github.com/jordan-rash/wasmcloud-provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wasmcloud/actor-tinygo"
+	httpserver "github.com/wasmcloud/interfaces/httpserver/tinygo"
+
+	"github.com/taction/http-provider-go/discovery"
+	"github.com/taction/http-provider-go/health"
+	"github.com/taction/http-provider-go/server"
+	"github.com/taction/http-provider-go/server/daprserver"
+	httpprovider "github.com/taction/http-provider-go/server/httpserver"
+	"github.com/taction/http-provider-go/transport"
+)
+
+// eventsMu guards the events recorded by the fakes, which run on the goroutines of the provider.
+var eventsMu sync.Mutex
+
+func record(events *[]string, e string) {
+	eventsMu.Lock()
+	*events = append(*events, e)
+	eventsMu.Unlock()
+}
+
+type fakeServer struct {
+	l       sync.Mutex
+	events  *[]string
+	serving bool
+}
+
+func (f *fakeServer) record(e string) {
+	record(f.events, e)
+}
+
+func (f *fakeServer) Run() error {
+	f.record("run")
+	return nil
+}
+
+func (f *fakeServer) Shutdown(ctx context.Context) error {
+	f.record("shutdown")
+	return nil
+}
+
+func (f *fakeServer) Update(conf provider.ActorConfig, tp transport.Transport) {
+	f.record("update " + conf.ActorConfig["unique_id"])
+}
+
+func (f *fakeServer) SetServing(serving bool) {
+	f.l.Lock()
+	f.serving = serving
+	f.l.Unlock()
+	if !serving {
+		f.record("not serving")
+	}
+}
+
+type fakeResolver struct {
+	events *[]string
+}
+
+func (f *fakeResolver) Init(metadata nameresolution.Metadata) error {
+	return nil
+}
+
+func (f *fakeResolver) ResolveID(req nameresolution.ResolveRequest) (string, error) {
+	return "", nil
+}
+
+func (f *fakeResolver) RegisterToDiscovery(a discovery.App) error {
+	record(f.events, "register "+a.AppID)
+	return nil
+}
+
+func (f *fakeResolver) RemoveFromDiscovery(a discovery.App) {
+	record(f.events, "deregister "+a.AppID)
+}
+
+func newTestProvider(events *[]string) *HttpServerProvider {
+	p := NewHttpServerProvider()
+	p.conn = transport.NewConnectionMonitor(nil, transport.QueueOptions{})
+	p.Resolver = &fakeResolver{events: events}
+	p.config, _ = loadConfig("")
+	p.config.DrainPeriod = Duration{}
+	p.newServer = func(conf provider.ActorConfig, tp transport.Transport) server.HttpServerInterface {
+		record(events, "new "+conf.ActorConfig["address"])
+		return &fakeServer{events: events}
+	}
+	return p
+}
+
+// flakyTransport fails every call while the actor is down.
+type flakyTransport struct {
+	down atomic.Bool
+}
+
+func (f *flakyTransport) Send(msg actor.Message) ([]byte, error) {
+	if f.down.Load() {
+		return nil, errors.New("actor unreachable")
+	}
+	return nil, nil
+}
+
+// a link without health_probe_path made unhealthy by failed calls serves again once the actor is back,
+// although no call reaches it in the meantime.
+func TestLinkRecovers(t *testing.T) {
+	var events []string
+	p := newTestProvider(&events)
+	p.config.Health.Interval = Duration{Duration: time.Millisecond}
+	p.config.Health.FailureThreshold = 2
+	tr := &flakyTransport{}
+	tr.down.Store(true)
+	checker := p.newChecker("MA", LinkConfig{AppID: "a"}, tr)
+	p.Actors["MA"] = &fakeServer{events: &events}
+	p.links["MA"] = &link{appID: "a", checker: checker, serving: true}
+	checker.Start()
+	defer checker.Stop()
+
+	rt := reportingTransport{Transport: tr, checker: checker}
+	for i := 0; i < 2; i++ {
+		_, err := rt.Send(actor.Message{Method: "HttpServer.HandleRequest"})
+		require.Error(t, err)
+	}
+	serving := func() bool {
+		p.l.Lock()
+		defer p.l.Unlock()
+		return p.links["MA"].serving
+	}
+	assert.False(t, serving())
+
+	tr.down.Store(false)
+	assert.Eventually(t, serving, time.Second, 5*time.Millisecond)
+}
+
+func TestDeleteLink(t *testing.T) {
+	var events []string
+	p := newTestProvider(&events)
+	p.Actors["MA"] = &fakeServer{events: &events}
+	p.links["MA"] = &link{appID: "a", app: discovery.App{AppID: "a"}, checker: health.NewChecker(health.Options{}, nil, nil)}
+
+	assert.NoError(t, p.DeleteLink("MA"))
+	assert.Equal(t, []string{"not serving", "deregister a", "shutdown"}, events)
+	assert.Empty(t, p.Actors)
+
+	// unknown actors are ignored
+	assert.NoError(t, p.DeleteLink("MA"))
+	assert.Len(t, events, 3)
+}
+
+func TestPutLinkUpdate(t *testing.T) {
+	ld := provider.LinkDefinition{ActorID: "MA", Values: map[string]string{"address": "0.0.0.0:8888", "unique_id": "a"}}
+
+	t.Run("unchanged link is a no-op", func(t *testing.T) {
+		var events []string
+		p := newTestProvider(&events)
+		assert.NoError(t, p.PutLink(ld))
+		assert.NoError(t, p.PutLink(ld))
+		assert.Equal(t, []string{"new 0.0.0.0:8888", "run", "register a"}, events)
+	})
+
+	t.Run("new unique id re-registers in place", func(t *testing.T) {
+		var events []string
+		p := newTestProvider(&events)
+		assert.NoError(t, p.PutLink(ld))
+		events = events[:0]
+		assert.NoError(t, p.PutLink(provider.LinkDefinition{ActorID: "MA", Values: map[string]string{"address": "0.0.0.0:8888", "unique_id": "b"}}))
+		assert.Equal(t, []string{"register b", "update b", "deregister a"}, events)
+		assert.Equal(t, "b", p.links["MA"].appID)
+	})
+
+	t.Run("new version re-registers the same instance", func(t *testing.T) {
+		var events []string
+		p := newTestProvider(&events)
+		assert.NoError(t, p.PutLink(ld))
+		events = events[:0]
+		assert.NoError(t, p.PutLink(provider.LinkDefinition{ActorID: "MA", Values: map[string]string{"address": "0.0.0.0:8888", "unique_id": "a", "version": "v2"}}))
+		assert.Equal(t, []string{"register a", "update a"}, events)
+		assert.Equal(t, "v2", p.links["MA"].app.Version)
+	})
+
+	t.Run("new address starts a listener before stopping the old one", func(t *testing.T) {
+		var events []string
+		p := newTestProvider(&events)
+		assert.NoError(t, p.PutLink(ld))
+		old := p.Actors["MA"]
+		events = events[:0]
+		assert.NoError(t, p.PutLink(provider.LinkDefinition{ActorID: "MA", Values: map[string]string{"address": "0.0.0.0:9999", "unique_id": "a"}}))
+		assert.Eventually(t, func() bool {
+			eventsMu.Lock()
+			defer eventsMu.Unlock()
+			return len(events) == 6
+		}, time.Second, time.Millisecond)
+		// the old instance is deregistered, the new one is registered under another instance id
+		assert.Equal(t, []string{"new 0.0.0.0:9999", "run", "register a", "not serving", "deregister a", "shutdown"}, events)
+		assert.NotSame(t, old, p.Actors["MA"])
+	})
+
+	t.Run("new protocol restarts the listener", func(t *testing.T) {
+		var events []string
+		p := newTestProvider(&events)
+		assert.NoError(t, p.PutLink(ld))
+		old := p.Actors["MA"]
+		events = events[:0]
+		assert.NoError(t, p.PutLink(provider.LinkDefinition{ActorID: "MA", Values: map[string]string{"address": "0.0.0.0:8888", "unique_id": "a", "protocol": "http"}}))
+		// the port is freed before the new listener binds it, the app is registered again with its new protocol
+		assert.Equal(t, []string{"not serving", "deregister a", "shutdown", "new 0.0.0.0:8888", "run", "register a"}, events)
+		assert.NotSame(t, old, p.Actors["MA"])
+		assert.Equal(t, discovery.ProtocolHTTP, p.links["MA"].app.Protocol)
+		assert.Empty(t, p.draining)
+	})
+
+	t.Run("new host on the same port stops the old listener first", func(t *testing.T) {
+		var events []string
+		p := newTestProvider(&events)
+		assert.NoError(t, p.PutLink(ld))
+		events = events[:0]
+		assert.NoError(t, p.PutLink(provider.LinkDefinition{ActorID: "MA", Values: map[string]string{"address": "127.0.0.1:8888", "unique_id": "a"}}))
+		assert.Equal(t, []string{"not serving", "deregister a", "shutdown", "new 127.0.0.1:8888", "run", "register a"}, events)
+	})
+}
+
+// a link put again while its deleted listener drains gets the port once the listener is free.
+func TestPutLinkWhileDraining(t *testing.T) {
+	ld := provider.LinkDefinition{ActorID: "MA", Values: map[string]string{"address": "0.0.0.0:8888", "unique_id": "a"}}
+	draining := func(p *HttpServerProvider) func() bool {
+		return func() bool {
+			p.l.Lock()
+			defer p.l.Unlock()
+			return len(p.draining) > 0
+		}
+	}
+
+	t.Run("same address takes the listener over", func(t *testing.T) {
+		var events []string
+		p := newTestProvider(&events)
+		p.config.DrainPeriod = Duration{Duration: time.Minute}
+		require.NoError(t, p.PutLink(ld))
+		s := p.Actors["MA"]
+		deleted := make(chan error)
+		go func() { deleted <- p.DeleteLink("MA") }()
+		require.Eventually(t, draining(p), time.Second, time.Millisecond)
+
+		require.NoError(t, p.PutLink(ld))
+		require.NoError(t, <-deleted)
+		assert.Same(t, s, p.Actors["MA"])
+		assert.True(t, p.links["MA"].serving)
+		assert.Equal(t, []string{"new 0.0.0.0:8888", "run", "register a", "not serving", "deregister a", "update a", "register a"}, events)
+		assert.Empty(t, p.draining)
+	})
+
+	t.Run("other address waits for the listener to stop", func(t *testing.T) {
+		var events []string
+		p := newTestProvider(&events)
+		p.config.DrainPeriod = Duration{Duration: 50 * time.Millisecond}
+		require.NoError(t, p.PutLink(ld))
+		deleted := make(chan error)
+		go func() { deleted <- p.DeleteLink("MA") }()
+		require.Eventually(t, draining(p), time.Second, time.Millisecond)
+
+		require.NoError(t, p.PutLink(provider.LinkDefinition{ActorID: "MB", Values: map[string]string{"address": "127.0.0.1:8888", "unique_id": "b"}}))
+		require.NoError(t, <-deleted)
+		assert.Equal(t, []string{"new 0.0.0.0:8888", "run", "register a", "not serving", "deregister a", "shutdown", "new 127.0.0.1:8888", "run", "register b"}, events)
+	})
+}
+
+func TestPutLinkRejected(t *testing.T) {
+	var events []string
+	p := newTestProvider(&events)
+	ld := provider.LinkDefinition{ActorID: "MA", Values: map[string]string{"address": "0.0.0.0:8888", "unique_id": "a"}}
+	assert.NoError(t, p.PutLink(ld))
+	events = events[:0]
+
+	// nothing is started for an invalid link
+	err := p.PutLink(provider.LinkDefinition{ActorID: "MB", Values: map[string]string{"address": "0.0.0.0:9999"}})
+	assert.EqualError(t, err, "link for actor MB rejected: unique_id is required, it is the dapr app id of the actor")
+	err = p.PutLink(provider.LinkDefinition{ActorID: "MB", Values: map[string]string{"address": "127.0.0.1:8888", "unique_id": "b"}})
+	assert.EqualError(t, err, `link for actor MB rejected: port 8888 of address "127.0.0.1:8888" is already used by the link of actor MA`)
+	assert.Empty(t, events)
+	assert.NotContains(t, p.Actors, "MB")
+	assert.Contains(t, p.rejected, "MB")
+
+	// a rejected update keeps the running link
+	err = p.PutLink(provider.LinkDefinition{ActorID: "MA", Values: map[string]string{"address": "0.0.0.0:8888", "unique_id": "a", "protocol": "tcp"}})
+	assert.Error(t, err)
+	assert.Empty(t, events)
+	assert.Equal(t, "a", p.links["MA"].appID)
+
+	// fixing or deleting the link clears the rejection
+	assert.NoError(t, p.PutLink(ld))
+	assert.NotContains(t, p.rejected, "MA")
+	assert.NoError(t, p.DeleteLink("MB"))
+	assert.Empty(t, p.rejected)
+}
+
+func TestNewServerProtocol(t *testing.T) {
+	p := NewHttpServerProvider()
+	grpcServer := p.newServer(provider.ActorConfig{ActorConfig: map[string]string{"address": "0.0.0.0:8888"}}, nil)
+	assert.IsType(t, &daprserver.Api{}, grpcServer)
+	httpServer := p.newServer(provider.ActorConfig{ActorConfig: map[string]string{"address": "0.0.0.0:8888", linkProtocol: "http"}}, nil)
+	assert.IsType(t, &httpprovider.HttpServer{}, httpServer)
+}
+
+// localServer is a grpc listener answering CallLocal with the method it got.
+type localServer struct {
+	fakeServer
+	internalv1pb.UnimplementedServiceInvocationServer
+	got *internalv1pb.InternalInvokeRequest
+}
+
+func (s *localServer) CallLocal(ctx context.Context, in *internalv1pb.InternalInvokeRequest) (*internalv1pb.InternalInvokeResponse, error) {
+	s.got = in
+	resp := invokev1.NewInvokeMethodResponse(http.StatusOK, "", nil).WithRawData([]byte("from "+in.Message.Method), "text/plain")
+	return resp.Proto(), nil
+}
+
+func TestLocalTarget(t *testing.T) {
+	var events []string
+	p := newTestProvider(&events)
+	target := &localServer{fakeServer: fakeServer{events: &events}}
+	p.Actors["MB"] = target
+	p.links["MB"] = &link{values: LinkConfig{AppID: "b", Protocol: discovery.ProtocolGRPC, Version: "v2"}, serving: true}
+	p.Actors["MC"] = &fakeServer{events: &events}
+	p.links["MC"] = &link{values: LinkConfig{AppID: "c", Protocol: discovery.ProtocolHTTP}, serving: true}
+
+	resp, err := p.callDaprRemote(context.Background(), "b", httpserver.HttpRequest{
+		Method:      "POST",
+		Path:        "neworder",
+		QueryString: "id=1",
+		Body:        []byte("{}"),
+		Header:      httpserver.HeaderMap{"dapr-app-id": {"b"}, "content-type": {"application/json"}, "traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint16(http.StatusOK), resp.StatusCode)
+	assert.Equal(t, []byte("from neworder"), resp.Body)
+	assert.Equal(t, httpserver.HeaderValues{"text/plain"}, resp.Header["content-type"])
+	// the listener gets what it would have got from the network
+	assert.Equal(t, "id=1", target.got.Message.HttpExtension.Querystring)
+	assert.Equal(t, []string{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}, target.got.Metadata["traceparent"].Values)
+
+	assert.Same(t, target, p.localTarget("b", ""))
+	assert.Same(t, target, p.localTarget("b", "v2"))
+	assert.Nil(t, p.localTarget("b", "v1"))
+	// http listeners don't serve dapr service invocation
+	assert.Nil(t, p.localTarget("c", ""))
+	// a version split is applied by the balancer, only calls for a version stay local
+	p.config.LoadBalancing.VersionSplit = map[string]map[string]int{"b": {"v1": 1, "v2": 0}}
+	assert.Nil(t, p.localTarget("b", ""))
+	assert.Same(t, target, p.localTarget("b", "v2"))
+	p.config.LoadBalancing.VersionSplit = nil
+	p.links["MB"].serving = false
+	assert.Nil(t, p.localTarget("b", ""))
+}
+
+// calls of actors carry the app id of their link and the namespace of the provider.
+func TestInvokeAppCaller(t *testing.T) {
+	var events []string
+	p := newTestProvider(&events)
+	p.config.Invocation.Namespace = "shop"
+	target := &localServer{fakeServer: fakeServer{events: &events}}
+	p.Actors["MB"] = target
+	p.links["MB"] = &link{values: LinkConfig{AppID: "b", Protocol: discovery.ProtocolGRPC}, serving: true}
+	p.links["MA"] = &link{values: LinkConfig{AppID: "a", Protocol: discovery.ProtocolHTTP}}
+
+	req := httpserver.HttpRequest{Method: "GET", Path: "stock", Header: httpserver.HeaderMap{"dapr-app-id": {"b"}, "dapr-caller-namespace": {"prod"}}}
+	appID, err := p.routeRequest("MA", &req)
+	require.NoError(t, err)
+	_, err = p.invokeApp(context.Background(), appID, req)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, target.got.Metadata["dapr-caller-app-id"].Values)
+	assert.Equal(t, []string{"shop"}, target.got.Metadata["dapr-caller-namespace"].Values)
+}
+
+func TestRouteRequest(t *testing.T) {
+	var events []string
+	p := newTestProvider(&events)
+	values, err := parseLinkConfig(map[string]string{
+		"address": "0.0.0.0:8888", "unique_id": "a", "default_target": "order-processor",
+		"routes": "/stock/*:inventory", "strip_headers": "Dapr-App-Id;x-route",
+	})
+	require.NoError(t, err)
+	p.links["MA"] = &link{values: values}
+
+	// the caller is the app of the link, whatever the actor sent
+	req := httpserver.HttpRequest{Path: "/stock/1", Header: httpserver.HeaderMap{"X-Route": {"stock"}, "accept": {"*/*"}, "Dapr-Caller-App-Id": {"admin"}}}
+	appID, err := p.routeRequest("MA", &req)
+	require.NoError(t, err)
+	assert.Equal(t, "inventory", appID)
+	assert.Equal(t, httpserver.HeaderMap{"accept": {"*/*"}, "dapr-caller-app-id": {"a"}}, req.Header)
+
+	// the header wins over the routes, and is stripped once read
+	req = httpserver.HttpRequest{Path: "/stock/1", Header: httpserver.HeaderMap{"dapr-app-id": {"checkout"}}}
+	appID, err = p.routeRequest("MA", &req)
+	require.NoError(t, err)
+	assert.Equal(t, "checkout", appID)
+	assert.Equal(t, httpserver.HeaderMap{"dapr-caller-app-id": {"a"}}, req.Header)
+
+	appID, err = p.routeRequest("MA", &httpserver.HttpRequest{Path: "/neworder", Header: httpserver.HeaderMap{}})
+	require.NoError(t, err)
+	assert.Equal(t, "order-processor", appID)
+
+	// actors without a link have to send the header
+	_, err = p.routeRequest("MB", &httpserver.HttpRequest{Path: "/neworder", Header: httpserver.HeaderMap{}})
+	assert.EqualError(t, err, "dapr-app-id not found, set the header or the default_target or routes values of the link")
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/taction/http-provider-go/discovery"
+	"github.com/taction/http-provider-go/loadbalancer"
+)
+
+// restartFields are the settings a reload can't apply to a running provider.
+var restartFields = []string{"version", "reconnect_queue_size", "reconnect_queue_timeout", "reload", "admin"}
+
+// watchConfig starts watching the reload source of the configuration, if any.
+func (p *HttpServerProvider) watchConfig(ctx context.Context) error {
+	r := p.config.Reload
+	switch {
+	case r.File != "":
+		go p.watchFile(ctx, r.File, r.Interval.Duration)
+	case r.Bucket != "":
+		js, err := p.Provider.NatsConnection.JetStream()
+		if err != nil {
+			return err
+		}
+		kv, err := js.KeyValue(r.Bucket)
+		if err != nil {
+			return err
+		}
+		w, err := kv.Watch(r.Key)
+		if err != nil {
+			return err
+		}
+		go p.watchKey(ctx, w)
+	}
+	return nil
+}
+
+// watchFile reloads the configuration when the content of path changes, a missing file applies no overlay.
+func (p *HttpServerProvider) watchFile(ctx context.Context, path string, interval time.Duration) {
+	var last []byte
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		b, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Warnf("read configuration %s: %s", path, err)
+		} else if last == nil || !bytes.Equal(b, last) {
+			last = append([]byte{}, b...)
+			p.reloadConfig(string(b))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// watchKey reloads the configuration on every update of the watched key, a deleted key removes the overlay.
+func (p *HttpServerProvider) watchKey(ctx context.Context, w nats.KeyWatcher) {
+	defer func() { _ = w.Stop() }()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case entry, ok := <-w.Updates():
+			if !ok {
+				return
+			}
+			// nil marks the end of the initial values
+			if entry == nil {
+				continue
+			}
+			if entry.Operation() == nats.KeyValuePut {
+				p.reloadConfig(string(entry.Value()))
+			} else {
+				p.reloadConfig("")
+			}
+		}
+	}
+}
+
+// reloadConfig applies overlay over the host configuration. An invalid overlay is ignored.
+func (p *HttpServerProvider) reloadConfig(overlay string) {
+	next, err := loadConfig(p.Provider.HostData.ConfigJson, overlay)
+	if err == nil {
+		err = next.Validate()
+	}
+	if err != nil {
+		log.Errorf("configuration reload ignored: %s", err)
+		return
+	}
+	applied, restart := p.applyConfig(next)
+	if len(applied) > 0 {
+		log.Infof("configuration reloaded, applied: %s", strings.Join(applied, ", "))
+	}
+	if len(restart) > 0 {
+		log.Warnf("configuration reloaded, restart the provider to apply: %s", strings.Join(restart, ", "))
+	}
+}
+
+// applyConfig makes next the current configuration and returns the settings which changed,
+// apart from the ones listed in restart which keep their current value until the provider restarts.
+func (p *HttpServerProvider) applyConfig(next ProviderConfig) (applied, restart []string) {
+	current := p.settings()
+	for _, name := range configChanges(current, next) {
+		if contains(restartFields, name) {
+			restart = append(restart, name)
+		} else {
+			applied = append(applied, name)
+		}
+	}
+	p.l.Lock()
+	p.restartNeeded = restart
+	p.l.Unlock()
+	// keep what can't change, so that the current configuration is the one in use
+	cv, nv := reflect.ValueOf(current), reflect.ValueOf(&next).Elem()
+	for i := 0; i < nv.NumField(); i++ {
+		if contains(restart, jsonName(nv.Type().Field(i))) {
+			nv.Field(i).Set(cv.Field(i))
+		}
+	}
+	if len(applied) == 0 {
+		return nil, restart
+	}
+
+	if contains(applied, "log") {
+		if err := next.Log.apply(); err != nil {
+			log.Errorf("apply log configuration: %s", err)
+		}
+	}
+	if contains(applied, "access_log") {
+		next.AccessLog.apply()
+	}
+	if contains(applied, "invocation") {
+		next.Invocation.Headers.apply()
+	}
+	resolverChanged := contains(applied, "resolver_address") || contains(applied, "external_address") ||
+		contains(applied, "name_resolution")
+	if resolverChanged {
+		if err := p.swapDiscovery(next); err != nil {
+			log.Errorf("name resolution not reloaded, keeping the current one: %s", err)
+			next.ResolverAddress, next.ExternalAddress = current.ResolverAddress, current.ExternalAddress
+			next.NameResolution = current.NameResolution
+			resolverChanged = false
+			applied = configChanges(current, next)
+		}
+	}
+
+	p.cl.Lock()
+	p.config = next
+	var old *RemoteConnectionPool
+	if resolverChanged || contains(applied, "load_balancing") || contains(applied, "remote") {
+		old = p.swapConnections()
+	}
+	p.cl.Unlock()
+	if old != nil {
+		retireConnections(old, next)
+	}
+	if contains(applied, "health") {
+		p.l.Lock()
+		for _, l := range p.links {
+			l.checker.SetOptions(next.Health.options())
+		}
+		p.l.Unlock()
+	}
+	return applied, restart
+}
+
+// swapConnections makes new calls use connections built from the current configuration and returns
+// the previous pool. It needs to be called with cl held.
+func (p *HttpServerProvider) swapConnections() *RemoteConnectionPool {
+	old := p.remoteConns
+	p.remoteConns = NewRemoteConnectionPool(p.config.Remote.MaxConnIdle.Duration, p.config.Remote.MinActiveConns)
+	p.endpoints = loadbalancer.NewResolverBuilder(p.Resolver, p.config.LoadBalancing.RefreshInterval.Duration)
+	return old
+}
+
+// retireConnections closes the connections of a replaced pool once the calls using them are drained.
+func retireConnections(pool *RemoteConnectionPool, c ProviderConfig) {
+	go func() {
+		time.Sleep(c.DrainPeriod.Duration + c.ShutdownTimeout.Duration)
+		pool.DestroyAll()
+	}()
+}
+
+// swapDiscovery moves the registrations of every link to the name resolution of c. The links are registered
+// to the new name resolution before they are removed from the current one, so that they stay resolvable, and
+// the name resolutions are called without holding l. The current name resolution is kept when the new one
+// can't be initialised.
+func (p *HttpServerProvider) swapDiscovery(c ProviderConfig) error {
+	resolver, err := newDiscovery(c)
+	if err != nil {
+		return err
+	}
+	p.l.Lock()
+	links := make(map[string]*link, len(p.links))
+	for actorID, l := range p.links {
+		links[actorID] = l
+	}
+	p.l.Unlock()
+
+	registered := make(map[string]discovery.App, len(links))
+	for actorID, l := range links {
+		app, err := p.registerApp(resolver, c.ExternalAddress, l)
+		if err != nil {
+			log.Errorf("register actor %s to the reloaded name resolution: %s", actorID, err)
+			continue
+		}
+		registered[actorID] = app
+	}
+
+	p.l.Lock()
+	p.cl.Lock()
+	old := p.Resolver
+	p.Resolver, p.ExternalHost = resolver, c.ExternalAddress
+	p.cl.Unlock()
+	previous := make(map[string]discovery.App)
+	var removed []discovery.App
+	for actorID, l := range links {
+		app, ok := registered[actorID]
+		if current := p.links[actorID]; current != l {
+			// the link was updated or deleted meanwhile, keep the registration of the current link
+			if ok && (current == nil || current.app.InstanceID() != app.InstanceID()) {
+				removed = append(removed, app)
+			}
+			continue
+		}
+		if l.app.AppID != "" {
+			previous[actorID] = l.app
+		}
+		l.app = app
+		p.updateServing(actorID)
+	}
+	p.l.Unlock()
+
+	for _, app := range removed {
+		resolver.RemoveFromDiscovery(app)
+	}
+	for _, app := range previous {
+		old.RemoveFromDiscovery(app)
+	}
+	if closer, ok := old.(io.Closer); ok {
+		_ = closer.Close()
+	}
+	// both name resolutions may share a backend, e.g. the same consul agent, where removing an instance
+	// from the old one removed the registration of the new one
+	for actorID, app := range previous {
+		if next, ok := registered[actorID]; ok && next.InstanceID() == app.InstanceID() {
+			if err := resolver.RegisterToDiscovery(next); err != nil {
+				log.Errorf("register actor %s to the reloaded name resolution: %s", actorID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// configChanges lists the top level settings which differ between a and b.
+func configChanges(a, b ProviderConfig) []string {
+	av, bv := reflect.ValueOf(a), reflect.ValueOf(b)
+	var changed []string
+	for i := 0; i < av.NumField(); i++ {
+		if !reflect.DeepEqual(av.Field(i).Interface(), bv.Field(i).Interface()) {
+			changed = append(changed, jsonName(av.Type().Field(i)))
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
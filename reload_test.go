@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dapr/kit/logger"
+	provider "github.com/jordan-rash/wasmcloud-provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/taction/http-provider-go/discovery"
+)
+
+func TestApplyConfig(t *testing.T) {
+	var events []string
+	p := newTestProvider(&events)
+	p.config.ExternalAddress = "127.0.0.1"
+	require.NoError(t, p.PutLink(provider.LinkDefinition{ActorID: "MA", Values: map[string]string{"address": "0.0.0.0:8888", "unique_id": "a"}}))
+	events = events[:0]
+
+	t.Run("restart needed", func(t *testing.T) {
+		next := p.settings()
+		next.Log.Level = "debug"
+		next.ReconnectQueueSize = 10
+		applied, restart := p.applyConfig(next)
+		assert.Equal(t, []string{"log"}, applied)
+		assert.Equal(t, []string{"reconnect_queue_size"}, restart)
+		assert.Equal(t, "debug", p.settings().Log.Level)
+		assert.Equal(t, 0, p.settings().ReconnectQueueSize)
+		assert.Contains(t, p.healthCheck().Message, "restart needed to apply: reconnect_queue_size")
+		assert.Empty(t, events)
+
+		// reverting the change clears the restart
+		next.ReconnectQueueSize = 0
+		_, restart = p.applyConfig(next)
+		assert.Empty(t, restart)
+		assert.NotContains(t, p.healthCheck().Message, "restart needed")
+	})
+
+	t.Run("connections are rebuilt", func(t *testing.T) {
+		pool := p.remoteConns
+		next := p.settings()
+		next.LoadBalancing.Policy = "weighted"
+		applied, _ := p.applyConfig(next)
+		assert.Equal(t, []string{"load_balancing"}, applied)
+		assert.NotSame(t, pool, p.remoteConns)
+		assert.Empty(t, events)
+	})
+
+	t.Run("links move to the new name resolution", func(t *testing.T) {
+		discovery.Register("reload-test", func(logger.Logger) discovery.Discover {
+			return &fakeResolver{events: &events}
+		})
+		next := p.settings()
+		next.NameResolution.Component = "reload-test"
+		next.ExternalAddress = "10.0.0.1"
+		applied, _ := p.applyConfig(next)
+		assert.Equal(t, []string{"external_address", "name_resolution"}, applied)
+		// the new registration is in place before the old one is removed
+		assert.Equal(t, []string{"register a", "deregister a"}, events)
+		assert.Equal(t, "10.0.0.1:8888", p.links["MA"].app.Address)
+	})
+
+	t.Run("name resolutions are called without holding the links", func(t *testing.T) {
+		events = events[:0]
+		discovery.Register("reload-test-2", func(logger.Logger) discovery.Discover {
+			return &lockCheckResolver{fakeResolver: fakeResolver{events: &events}, p: p}
+		})
+		next := p.settings()
+		next.NameResolution.Component = "reload-test-2"
+		applied, _ := p.applyConfig(next)
+		assert.Equal(t, []string{"name_resolution"}, applied)
+		// the instance is the same, it is registered again in case both name resolutions share a backend
+		assert.Equal(t, []string{"register a", "deregister a", "register a"}, events)
+	})
+
+	t.Run("health options apply to running links", func(t *testing.T) {
+		next := p.settings()
+		next.Health.FailureThreshold = 1
+		applied, _ := p.applyConfig(next)
+		assert.Equal(t, []string{"health"}, applied)
+		p.links["MA"].checker.Report(errors.New("failed"))
+		assert.False(t, p.links["MA"].checker.Healthy())
+	})
+}
+
+// lockCheckResolver fails the registrations made while the links of p are locked.
+type lockCheckResolver struct {
+	fakeResolver
+	p *HttpServerProvider
+}
+
+func (r *lockCheckResolver) RegisterToDiscovery(a discovery.App) error {
+	if !r.p.l.TryLock() {
+		return errors.New("registered with the links locked")
+	}
+	r.p.l.Unlock()
+	return r.fakeResolver.RegisterToDiscovery(a)
+}
+
+func TestWatchFile(t *testing.T) {
+	var events []string
+	p := newTestProvider(&events)
+	path := filepath.Join(t.TempDir(), "provider.json")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.watchFile(ctx, path, 10*time.Millisecond)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"external_address": "127.0.0.1", "drain_period": "1s"}`), 0o644))
+	assert.Eventually(t, func() bool {
+		return p.settings().DrainPeriod.Duration == time.Second
+	}, time.Second, 10*time.Millisecond)
+
+	// an invalid document is ignored
+	require.NoError(t, os.WriteFile(path, []byte(`{"external_address": "127.0.0.1", "drain_period": "soon"}`), 0o644))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, time.Second, p.settings().DrainPeriod.Duration)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"external_address": "127.0.0.1", "drain_period": "2s"}`), 0o644))
+	assert.Eventually(t, func() bool {
+		return p.settings().DrainPeriod.Duration == 2*time.Second
+	}, time.Second, 10*time.Millisecond)
+}
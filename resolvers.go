@@ -0,0 +1,30 @@
+package main
+
+import (
+	"github.com/dapr/kit/logger"
+
+	"github.com/taction/http-provider-go/discovery"
+	"github.com/taction/http-provider-go/discovery/consul"
+	"github.com/taction/http-provider-go/discovery/kubernetes"
+	"github.com/taction/http-provider-go/discovery/mdns"
+	"github.com/taction/http-provider-go/discovery/standalone"
+	"github.com/taction/http-provider-go/discovery/static"
+)
+
+func init() {
+	discovery.Register("consul", func(logger logger.Logger) discovery.Discover {
+		return consul.NewResolver(logger)
+	})
+	discovery.Register("mdns", func(logger logger.Logger) discovery.Discover {
+		return mdns.NewResolver(logger)
+	})
+	discovery.Register("kubernetes", func(logger logger.Logger) discovery.Discover {
+		return kubernetes.NewResolver(logger)
+	})
+	discovery.Register("static", func(logger logger.Logger) discovery.Discover {
+		return static.NewResolver(logger)
+	})
+	discovery.Register("standalone", func(logger logger.Logger) discovery.Discover {
+		return standalone.NewResolver(logger)
+	})
+}
@@ -13,6 +13,8 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/dapr/dapr/pkg/messages"
 	invokev1 "github.com/dapr/dapr/pkg/messaging/v1"
@@ -31,11 +33,14 @@ import (
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
+	"github.com/taction/http-provider-go/accesslog"
 	"github.com/taction/http-provider-go/encode"
+	logging "github.com/taction/http-provider-go/log"
+	"github.com/taction/http-provider-go/server"
 	"github.com/taction/http-provider-go/transport"
 )
 
-var log = logger.NewLogger("wasmcloud.dapr.server")
+var log = logging.NewLogger("wasmcloud.dapr.server")
 
 type API interface {
 	// DaprInternal Service methods
@@ -53,18 +58,24 @@ type Api struct {
 	internalv1pb.UnimplementedServiceInvocationServer
 	Conf     provider.ActorConfig
 	UniqueID string
+	l        sync.RWMutex
 	tp       transport.Transport
+	log      logger.Logger
 	server   *grpcGo.Server
+	health   *health.Server
 }
 
 func New(conf provider.ActorConfig, tp transport.Transport) *Api {
 	uniqueId := conf.ActorConfig["unique_id"]
-	return &Api{Conf: conf, UniqueID: uniqueId, tp: tp}
+	return &Api{Conf: conf, UniqueID: uniqueId, tp: tp, log: logging.WithLink(log, conf.ActorID, uniqueId), health: health.NewServer()}
 }
 
 func (a *Api) Run() error {
-	addr := a.Conf.ActorConfig["address"]
-	ln, err := net.Listen("tcp", addr)
+	// Update may change the config while the server runs
+	a.l.RLock()
+	conf := a.Conf
+	a.l.RUnlock()
+	ln, err := net.Listen("tcp", conf.ActorConfig["address"])
 	if err != nil {
 		return err
 	}
@@ -80,15 +91,57 @@ func (a *Api) Run() error {
 	a.server = s
 	go func() {
 		internalv1pb.RegisterServiceInvocationServer(s, a)
-		healthpb.RegisterHealthServer(s, health.NewServer())
+		healthpb.RegisterHealthServer(s, a.health)
 		err := s.Serve(ln)
-		log.Infof("Server for actor [%s] stopped with err: %s", a.Conf.ActorID, err)
+		a.logger().Infof("Server for actor [%s] stopped with err: %s", conf.ActorID, err)
 	}()
 	return nil
 }
 
-func (a *Api) Shutdown() {
-	a.server.GracefulStop()
+func (a *Api) Shutdown(ctx context.Context) error {
+	if a.server == nil {
+		return nil
+	}
+	stopped := make(chan struct{})
+	go func() {
+		a.server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		a.server.Stop()
+		return ctx.Err()
+	}
+}
+
+func (a *Api) Update(conf provider.ActorConfig, tp transport.Transport) {
+	a.l.Lock()
+	a.Conf, a.UniqueID, a.tp = conf, conf.ActorConfig["unique_id"], tp
+	a.log = logging.WithLink(log, conf.ActorID, a.UniqueID)
+	a.l.Unlock()
+}
+
+func (a *Api) getTransport() transport.Transport {
+	a.l.RLock()
+	defer a.l.RUnlock()
+	return a.tp
+}
+
+// logger returns the logger with the correlation fields of the link.
+func (a *Api) logger() logger.Logger {
+	a.l.RLock()
+	defer a.l.RUnlock()
+	return a.log
+}
+
+func (a *Api) SetServing(serving bool) {
+	st := healthpb.HealthCheckResponse_SERVING //nolint:nosnakecase
+	if !serving {
+		st = healthpb.HealthCheckResponse_NOT_SERVING //nolint:nosnakecase
+	}
+	a.health.SetServingStatus("", st)
 }
 
 // CallLocal is used for internal dapr to dapr calls. It is invoked by another Dapr instance with a request to the local app.
@@ -133,40 +186,65 @@ func (a *Api) InvokeMethod(ctx context.Context, req *invokev1.InvokeMethodReques
 	return rsp, err
 }
 
-func (a *Api) invokeMethodV1(ctx context.Context, r *invokev1.InvokeMethodRequest) (*invokev1.InvokeMethodResponse, error) {
+func (a *Api) invokeMethodV1(ctx context.Context, r *invokev1.InvokeMethodRequest) (rsp *invokev1.InvokeMethodResponse, err error) {
+	start := time.Now()
 	req, err := a.constructRequest(ctx, r)
 	if err != nil {
 		return nil, err
 	}
+	var resp httpserver.HttpResponse
+	if accesslog.Enabled() {
+		defer func() {
+			a.accessLog("grpc", req, resp, time.Since(start), err)
+		}()
+	}
 
-	//var resp *http.Response
-
-	log.Debugf("Sending request to actor with request: %+v", req)
 	body, err := encode.Encode(req)
 	if err != nil {
-		log.Warnf("Sending request to actor encode request err: %s", err)
+		a.logger().Warnf("Sending request to actor encode request err: %s", err)
 		return nil, err
 	}
-	res, err := a.tp.Send(actor.Message{Method: "HttpServer.HandleRequest", Arg: body})
+	res, err := a.getTransport().Send(actor.Message{Method: "HttpServer.HandleRequest", Arg: body})
 	if err != nil {
-		log.Warnf("Sending request to actor err: %s", err)
+		a.logger().Warnf("Sending request to actor err: %s", err)
 		return nil, err
 	}
-	resp := httpserver.HttpResponse{}
 	b := msgpack.NewDecoder(res)
 	resp, err = httpserver.MDecodeHttpResponse(&b)
-	//err = msgpack.Unmarshal(res, &resp)
 	if err != nil {
-		log.Warnf("Sending request to actor decode resp err: %s", err)
+		a.logger().Warnf("Sending request to actor decode resp err: %s", err)
 		return nil, err
 	}
 
-	rsp, err := a.parseChannelResponse(resp)
+	return a.parseChannelResponse(resp)
+}
+
+func (a *Api) accessLog(protocol string, req *httpserver.HttpRequest, resp httpserver.HttpResponse, latency time.Duration, err error) {
+	headers := accesslog.FromHeaderMap(req.Header)
+	status := int(resp.StatusCode)
 	if err != nil {
-		return nil, err
+		status = http.StatusInternalServerError
 	}
-
-	return rsp, nil
+	a.l.RLock()
+	appID := a.UniqueID
+	a.l.RUnlock()
+	accesslog.Log(accesslog.Entry{
+		Direction:     accesslog.Inbound,
+		Protocol:      protocol,
+		CallerAppID:   accesslog.Header(headers, accesslog.CallerAppIDHeader),
+		TargetAppID:   appID,
+		Method:        req.Method,
+		Path:          req.Path,
+		Status:        status,
+		Latency:       latency,
+		RequestBytes:  len(req.Body),
+		ResponseBytes: len(resp.Body),
+		TraceID:       accesslog.TraceID(headers),
+		Headers:       headers,
+		RequestBody:   req.Body,
+		ResponseBody:  resp.Body,
+		Err:           err,
+	})
 }
 
 func (a *Api) parseChannelResponse(resp httpserver.HttpResponse) (*invokev1.InvokeMethodResponse, error) {
@@ -215,6 +293,7 @@ func (a *Api) constructRequest(ctx context.Context, req *invokev1.InvokeMethodRe
 	// Recover headers
 	invokev1.InternalMetadataToHTTPHeader(ctx, req.Metadata(), he.Set)
 	he.Set("content-type", ct)
+	server.SetInvocationHeaders(ctx, httpserver.HeaderMap(he))
 
 	//return channelReq, nil
 	return &httpserver.HttpRequest{
@@ -227,28 +306,38 @@ func (a *Api) constructRequest(ctx context.Context, req *invokev1.InvokeMethodRe
 }
 
 func (a *Api) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	log.Infof("Received request")
 	if r.URL.Path == "/v1.0/healthz" {
+		res, _ := a.health.Check(r.Context(), &healthpb.HealthCheckRequest{})
+		if res.GetStatus() != healthpb.HealthCheckResponse_SERVING { //nolint:nosnakecase
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
+	start := time.Now()
 	req, err := transferRequest(r)
 	if err != nil {
 		a.handleError(w, err)
 		return
 	}
-	log.Infof("Sending request to actor with request: %+v", req)
+	server.SetHTTPInvocationHeaders(r.Context(), req.Header)
+	resp := httpserver.HttpResponse{}
+	if accesslog.Enabled() {
+		defer func() {
+			a.accessLog("http", req, resp, time.Since(start), err)
+		}()
+	}
 	body, err := encode.Encode(req)
 	if err != nil {
 		a.handleError(w, err)
 		return
 	}
-	res, err := a.tp.Send(actor.Message{Method: "HttpServer.HandleRequest", Arg: body})
+	res, err := a.getTransport().Send(actor.Message{Method: "HttpServer.HandleRequest", Arg: body})
 	if err != nil {
 		a.handleError(w, err)
 		return
 	}
-	resp := httpserver.HttpResponse{}
 	b := msgpack.NewDecoder(res)
 	resp, err = httpserver.MDecodeHttpResponse(&b)
 	//err = msgpack.Unmarshal(res, &resp)
@@ -264,13 +353,12 @@ func (a *Api) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(int(resp.StatusCode))
 	// try transfer msgpack to json
 	var v map[string]interface{}
-	err = commonmsgpack.Unmarshal(resp.Body, &v)
-	if err != nil {
+	if err := commonmsgpack.Unmarshal(resp.Body, &v); err != nil {
 		w.Write(resp.Body)
 		return
 	}
-	j, err := json.Marshal(v)
-	if err != nil {
+	j, jsonErr := json.Marshal(v)
+	if jsonErr != nil {
 		w.Write(resp.Body)
 		return
 	}
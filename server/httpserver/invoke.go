@@ -0,0 +1,100 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dapr/dapr/pkg/messages"
+	httpserver "github.com/wasmcloud/interfaces/httpserver/tinygo"
+
+	"github.com/taction/http-provider-go/server"
+)
+
+const (
+	// invokePrefix is the path of the dapr service invocation api: /v1.0/invoke/{app-id}/method/{method}.
+	invokePrefix = "/v1.0/invoke/"
+	// daprAppIDHeader names the app a request sent with an Invoker is for.
+	daprAppIDHeader = "dapr-app-id"
+
+	errDirectInvoke = "ERR_DIRECT_INVOKE"
+)
+
+// Invoker calls the app named by the dapr-app-id header of req, the way linked actors call other apps.
+type Invoker func(ctx context.Context, req httpserver.HttpRequest) (*httpserver.HttpResponse, error)
+
+// parseInvokePath returns the app id and the method of an invoke api path.
+func parseInvokePath(path string) (appID, method string, err error) {
+	appID, rest, _ := strings.Cut(strings.TrimPrefix(path, invokePrefix), "/")
+	if appID == "" {
+		return "", "", errors.New(messages.ErrDirectInvokeNoAppID)
+	}
+	method = strings.TrimPrefix(rest, "method/")
+	if method == rest || method == "" {
+		return "", "", errors.New(messages.ErrDirectInvokeMethod)
+	}
+	return appID, method, nil
+}
+
+// serveInvoke implements the dapr invoke api: calls for the linked actor are sent to it,
+// the others to the app they name through the provider.
+func (h *HttpServer) serveInvoke(w http.ResponseWriter, r *http.Request) {
+	appID, method, err := parseInvokePath(r.URL.Path)
+	if err != nil {
+		writeDaprError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	start := time.Now()
+	req, err := transferRequest(r)
+	if err != nil {
+		h.handleError(w, err)
+		return
+	}
+	req.Path, req.QueryString = method, r.URL.RawQuery
+
+	h.l.RLock()
+	caller := h.UniqueID
+	h.l.RUnlock()
+	if appID == caller {
+		// the actor gets the path it would get from a direct request
+		req.Path = "/" + strings.TrimPrefix(method, "/")
+		h.serveActor(r.Context(), w, req, start)
+		return
+	}
+	if h.invoke == nil {
+		writeDaprError(w, http.StatusNotImplemented, messages.ErrDirectInvokeNotReady)
+		return
+	}
+	// the app id of the path wins over a header sent by the client
+	for k := range req.Header {
+		if strings.EqualFold(k, daprAppIDHeader) {
+			delete(req.Header, k)
+		}
+	}
+	req.Header[daprAppIDHeader] = httpserver.HeaderValues{appID}
+	server.SetCaller(req.Header, caller)
+	resp, err := h.invoke(r.Context(), *req)
+	if err != nil {
+		h.logger().Warnf("invoke %s of app %s: %s", method, appID, err)
+		writeDaprError(w, http.StatusInternalServerError, fmt.Sprintf(messages.ErrDirectInvoke, appID, err))
+		return
+	}
+	for k, v := range resp.Header {
+		if len(v) > 0 && v[0] != "" {
+			w.Header().Set(k, v[0])
+		}
+	}
+	w.WriteHeader(int(resp.StatusCode))
+	w.Write(resp.Body)
+}
+
+// writeDaprError replies with an error body of the dapr http api.
+func writeDaprError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"errorCode": errDirectInvoke, "message": message})
+}
@@ -9,7 +9,11 @@ import (
 	"context"
 	"encoding/json"
 	"io"
+	"net"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dapr/kit/logger"
@@ -19,63 +23,134 @@ import (
 	httpserver "github.com/wasmcloud/interfaces/httpserver/tinygo"
 	msgpack "github.com/wasmcloud/tinygo-msgpack"
 
+	"github.com/taction/http-provider-go/accesslog"
 	"github.com/taction/http-provider-go/encode"
+	logging "github.com/taction/http-provider-go/log"
+	"github.com/taction/http-provider-go/server"
 	"github.com/taction/http-provider-go/transport"
 )
 
-var log = logger.NewLogger("wasmcloud.http.server")
+var log = logging.NewLogger("wasmcloud.http.server")
 
 type HttpServer struct {
 	Conf     provider.ActorConfig
 	UniqueID string
 	server   *http.Server
+	l        sync.RWMutex
 	tp       transport.Transport
+	log      logger.Logger
+	serving  atomic.Bool
+	// invoke serves the dapr invoke api for other apps than the linked actor.
+	invoke Invoker
 }
 
-func New(conf provider.ActorConfig, tp transport.Transport) *HttpServer {
+func New(conf provider.ActorConfig, tp transport.Transport, invoke Invoker) *HttpServer {
 	uniqueId := conf.ActorConfig["unique_id"]
-	return &HttpServer{Conf: conf, UniqueID: uniqueId, tp: tp}
+	h := &HttpServer{Conf: conf, UniqueID: uniqueId, tp: tp, log: logging.WithLink(log, conf.ActorID, uniqueId), invoke: invoke}
+	h.serving.Store(true)
+	return h
 }
 
 func (h *HttpServer) Run() error {
-	address := h.Conf.ActorConfig["address"]
+	// Update may change the config while the server runs
+	h.l.RLock()
+	conf := h.Conf
+	h.l.RUnlock()
+	address := conf.ActorConfig["address"]
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
 	h.server = &http.Server{Addr: address, Handler: h}
 	go func() {
-		h.server.ListenAndServe()
+		if err := h.server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			h.logger().Errorf("Server for actor [%s] stopped with err: %s", conf.ActorID, err)
+		}
 	}()
 	return nil
 }
 
-func (h *HttpServer) Shutdown() {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+func (h *HttpServer) Shutdown(ctx context.Context) error {
+	if h.server == nil {
+		return nil
+	}
 	err := h.server.Shutdown(ctx)
-	log.Errorf("Error shutting down server for actor [%s] err: %s", h.Conf.ActorID, err)
-	cancel()
+	if err != nil {
+		h.l.RLock()
+		actorID := h.Conf.ActorID
+		h.l.RUnlock()
+		h.logger().Errorf("Error shutting down server for actor [%s] err: %s", actorID, err)
+		h.server.Close()
+	}
+	return err
+}
+
+func (h *HttpServer) Update(conf provider.ActorConfig, tp transport.Transport) {
+	h.l.Lock()
+	h.Conf, h.UniqueID, h.tp = conf, conf.ActorConfig["unique_id"], tp
+	h.log = logging.WithLink(log, conf.ActorID, h.UniqueID)
+	h.l.Unlock()
+}
+
+func (h *HttpServer) getTransport() transport.Transport {
+	h.l.RLock()
+	defer h.l.RUnlock()
+	return h.tp
+}
+
+// logger returns the logger with the correlation fields of the link.
+func (h *HttpServer) logger() logger.Logger {
+	h.l.RLock()
+	defer h.l.RUnlock()
+	return h.log
+}
+
+func (h *HttpServer) SetServing(serving bool) {
+	h.serving.Store(serving)
 }
 
 func (h *HttpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	log.Infof("Received request")
 	if r.URL.Path == "/v1.0/healthz" {
+		if !h.serving.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
+	if strings.HasPrefix(r.URL.Path, invokePrefix) {
+		h.serveInvoke(w, r)
+		return
+	}
+	start := time.Now()
 	req, err := transferRequest(r)
 	if err != nil {
 		h.handleError(w, err)
 		return
 	}
-	log.Infof("Sending request to actor with request: %+v", req)
+	h.serveActor(r.Context(), w, req, start)
+}
+
+// serveActor sends req to the linked actor.
+func (h *HttpServer) serveActor(ctx context.Context, w http.ResponseWriter, req *httpserver.HttpRequest, start time.Time) {
+	var err error
+	resp := httpserver.HttpResponse{}
+	if accesslog.Enabled() {
+		defer func() {
+			h.accessLog(req, resp, time.Since(start), err)
+		}()
+	}
+	server.SetHTTPInvocationHeaders(ctx, req.Header)
 	body, err := encode.Encode(req)
 	if err != nil {
 		h.handleError(w, err)
 		return
 	}
-	res, err := h.tp.Send(actor.Message{Method: "HttpServer.HandleRequest", Arg: body})
+	res, err := h.getTransport().Send(actor.Message{Method: "HttpServer.HandleRequest", Arg: body})
 	if err != nil {
 		h.handleError(w, err)
 		return
 	}
-	resp := httpserver.HttpResponse{}
 	b := msgpack.NewDecoder(res)
 	resp, err = httpserver.MDecodeHttpResponse(&b)
 	//err = msgpack.Unmarshal(res, &resp)
@@ -91,19 +166,46 @@ func (h *HttpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(int(resp.StatusCode))
 	// try transfer msgpack to json
 	var v map[string]interface{}
-	err = commonmsgpack.Unmarshal(resp.Body, &v)
-	if err != nil {
+	if err := commonmsgpack.Unmarshal(resp.Body, &v); err != nil {
 		w.Write(resp.Body)
 		return
 	}
-	j, err := json.Marshal(v)
-	if err != nil {
+	j, jsonErr := json.Marshal(v)
+	if jsonErr != nil {
 		w.Write(resp.Body)
 		return
 	}
 	w.Write(j)
 }
 
+func (h *HttpServer) accessLog(req *httpserver.HttpRequest, resp httpserver.HttpResponse, latency time.Duration, err error) {
+	headers := accesslog.FromHeaderMap(req.Header)
+	status := int(resp.StatusCode)
+	if err != nil {
+		status = http.StatusInternalServerError
+	}
+	h.l.RLock()
+	appID := h.UniqueID
+	h.l.RUnlock()
+	accesslog.Log(accesslog.Entry{
+		Direction:     accesslog.Inbound,
+		Protocol:      "http",
+		CallerAppID:   accesslog.Header(headers, accesslog.CallerAppIDHeader),
+		TargetAppID:   appID,
+		Method:        req.Method,
+		Path:          req.Path,
+		Status:        status,
+		Latency:       latency,
+		RequestBytes:  len(req.Body),
+		ResponseBytes: len(resp.Body),
+		TraceID:       accesslog.TraceID(headers),
+		Headers:       headers,
+		RequestBody:   req.Body,
+		ResponseBody:  resp.Body,
+		Err:           err,
+	})
+}
+
 func (h *HttpServer) handleError(w http.ResponseWriter, err error) {
 	w.WriteHeader(http.StatusInternalServerError)
 	w.Write([]byte(err.Error()))
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	httpserver "github.com/wasmcloud/interfaces/httpserver/tinygo"
+
+	"github.com/taction/http-provider-go/accesslog"
+)
+
+// Headers added to the requests sent to actors.
+const (
+	CallerAppIDHeader     = accesslog.CallerAppIDHeader
+	CallerNamespaceHeader = "dapr-caller-namespace"
+	// DeadlineHeader is the deadline of the call in RFC 3339 format.
+	DeadlineHeader = "dapr-deadline"
+	// TraceIDHeader is the trace id of the traceparent of the call.
+	TraceIDHeader = "dapr-trace-id"
+)
+
+// InvocationHeaders selects the metadata of a call exposed to the actor.
+type InvocationHeaders struct {
+	CallerAppID     bool
+	CallerNamespace bool
+	Deadline        bool
+	TraceID         bool
+}
+
+var (
+	l       sync.RWMutex
+	exposed = InvocationHeaders{CallerAppID: true, CallerNamespace: true, Deadline: true, TraceID: true}
+)
+
+// ApplyInvocationHeaders replaces the metadata exposed to actors.
+func ApplyInvocationHeaders(h InvocationHeaders) {
+	l.Lock()
+	exposed = h
+	l.Unlock()
+}
+
+// SetInvocationHeaders normalizes the metadata headers of a request to an actor: the caller headers, whatever
+// their case, the deadline of ctx and the trace id of the traceparent header, the ones not exposed are removed.
+func SetInvocationHeaders(ctx context.Context, h httpserver.HeaderMap) {
+	l.RLock()
+	e := exposed
+	l.RUnlock()
+
+	headers := accesslog.FromHeaderMap(h)
+	values := map[string]string{
+		CallerAppIDHeader:     accesslog.Header(headers, CallerAppIDHeader),
+		CallerNamespaceHeader: accesslog.Header(headers, CallerNamespaceHeader),
+		DeadlineHeader:        "",
+		TraceIDHeader:         accesslog.TraceID(headers),
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		values[DeadlineHeader] = deadline.UTC().Format(time.RFC3339Nano)
+	}
+	enabled := map[string]bool{
+		CallerAppIDHeader:     e.CallerAppID,
+		CallerNamespaceHeader: e.CallerNamespace,
+		DeadlineHeader:        e.Deadline,
+		TraceIDHeader:         e.TraceID,
+	}
+	for name, value := range values {
+		removeHeader(h, name)
+		if enabled[name] && value != "" {
+			h[name] = httpserver.HeaderValues{value}
+		}
+	}
+}
+
+// SetHTTPInvocationHeaders normalizes the metadata headers of a request received over plain http. Its callers are
+// not authenticated, the caller headers they send are dropped.
+func SetHTTPInvocationHeaders(ctx context.Context, h httpserver.HeaderMap) {
+	SetCaller(h, "")
+	SetInvocationHeaders(ctx, h)
+}
+
+// SetCaller replaces the caller headers of a request leaving the provider with the app id of the calling link,
+// so an actor or a client cannot call in the name of another app.
+func SetCaller(h httpserver.HeaderMap, appID string) {
+	removeHeader(h, CallerAppIDHeader)
+	removeHeader(h, CallerNamespaceHeader)
+	if appID != "" {
+		h[CallerAppIDHeader] = httpserver.HeaderValues{appID}
+	}
+}
+
+func removeHeader(h httpserver.HeaderMap, name string) {
+	for k := range h {
+		if strings.EqualFold(k, name) {
+			delete(h, k)
+		}
+	}
+}
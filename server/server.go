@@ -1,6 +1,20 @@
 package server
 
+import (
+	"context"
+
+	provider "github.com/jordan-rash/wasmcloud-provider"
+
+	"github.com/taction/http-provider-go/transport"
+)
+
 type HttpServerInterface interface {
 	Run() error
-	Shutdown()
+	// Shutdown stops accepting calls and waits for in-flight ones until ctx is done,
+	// then closes the remaining connections.
+	Shutdown(ctx context.Context) error
+	// Update swaps the link configuration and transport of a running server, the listen address is kept.
+	Update(conf provider.ActorConfig, tp transport.Transport)
+	// SetServing changes the health status reported by the server.
+	SetServing(serving bool)
 }
@@ -6,19 +6,187 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	provider "github.com/jordan-rash/wasmcloud-provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wasmcloud/actor-tinygo"
+	tinygo "github.com/wasmcloud/interfaces/httpserver/tinygo"
+	msgpack "github.com/wasmcloud/tinygo-msgpack"
+
+	"github.com/taction/http-provider-go/encode"
+	"github.com/taction/http-provider-go/server"
+	"github.com/taction/http-provider-go/server/daprserver"
+	"github.com/taction/http-provider-go/server/httpserver"
+	"github.com/taction/http-provider-go/transport"
 )
 
-// generate a HttpServer and run.
+// generate a HttpServer and check its health endpoint follows the serving status.
 func TestServer(t *testing.T) {
 	conf := provider.ActorConfig{ActorID: "a", ActorConfig: map[string]string{"address": "0.0.0.0:8888", "unique_id": "a"}}
-	server := New(conf, nil)
-	err := server.Run()
+	server := httpserver.New(conf, nil, nil)
+
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1.0/healthz", nil))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	server.SetServing(false)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1.0/healthz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+// the http listener forwards the dapr invoke api to other apps.
+func TestServerInvoke(t *testing.T) {
+	var got tinygo.HttpRequest
+	invoke := func(ctx context.Context, req tinygo.HttpRequest) (*tinygo.HttpResponse, error) {
+		got = req
+		if req.Header["dapr-app-id"][0] == "down" {
+			return nil, errors.New("no healthy instance")
+		}
+		return &tinygo.HttpResponse{StatusCode: http.StatusCreated, Header: tinygo.HeaderMap{"content-type": {"text/plain"}}, Body: []byte("created")}, nil
+	}
+	conf := provider.ActorConfig{ActorID: "a", ActorConfig: map[string]string{"address": "0.0.0.0:8888", "unique_id": "a"}}
+	server := httpserver.New(conf, nil, invoke)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/v1.0/invoke/orders/method/neworder/v2?id=1", strings.NewReader("{}"))
+	r.Header.Set("Dapr-App-Id", "other")
+	server.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "created", w.Body.String())
+	assert.Equal(t, "text/plain", w.Header().Get("Content-Type"))
+	assert.Equal(t, "POST", got.Method)
+	assert.Equal(t, "neworder/v2", got.Path)
+	assert.Equal(t, "id=1", got.QueryString)
+	assert.Equal(t, []byte("{}"), got.Body)
+	assert.Equal(t, tinygo.HeaderValues{"orders"}, got.Header["dapr-app-id"])
+	assert.NotContains(t, got.Header, "Dapr-App-Id")
+	assert.Equal(t, tinygo.HeaderValues{"a"}, got.Header["dapr-caller-app-id"])
+
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1.0/invoke/down/method/status", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.JSONEq(t, `{"errorCode":"ERR_DIRECT_INVOKE","message":"fail to invoke, id: down, err: no healthy instance"}`, w.Body.String())
+
+	// calls of the linked actor itself are sent to it with the path of a direct request
+	tp := &actorTransport{}
+	w = httptest.NewRecorder()
+	httpserver.New(conf, tp, invoke).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1.0/invoke/a/method/orders/1?full=true", strings.NewReader("{}")))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "/orders/1", tp.got.Path)
+	assert.Equal(t, "full=true", tp.got.QueryString)
+
+	for _, path := range []string{"/v1.0/invoke/orders", "/v1.0/invoke/orders/neworder", "/v1.0/invoke//method/neworder"} {
+		w = httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		require.Equal(t, http.StatusBadRequest, w.Code, path)
+	}
+}
+
+// requests sent to actors carry normalized caller, deadline and trace id headers.
+func TestInvocationHeaders(t *testing.T) {
+	defer server.ApplyInvocationHeaders(server.InvocationHeaders{CallerAppID: true, CallerNamespace: true, Deadline: true, TraceID: true})
+	deadline := time.Date(2022, 12, 21, 10, 0, 0, 0, time.UTC)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	h := func() tinygo.HeaderMap {
+		return tinygo.HeaderMap{
+			"Dapr-Caller-App-Id":    {"checkout"},
+			"dapr-caller-namespace": {"shop"},
+			"traceparent":           {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+			"Dapr-Trace-Id":         {"forged"},
+		}
+	}
+
+	// the caller headers of dapr service invocation come from the internal metadata of the call
+	got := h()
+	server.SetInvocationHeaders(ctx, got)
+	assert.Equal(t, tinygo.HeaderMap{
+		"dapr-caller-app-id":    {"checkout"},
+		"dapr-caller-namespace": {"shop"},
+		"dapr-deadline":         {"2022-12-21T10:00:00Z"},
+		"dapr-trace-id":         {"4bf92f3577b34da6a3ce929d0e0e4736"},
+		"traceparent":           {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+	}, got)
+
+	// headers not exposed are removed, even when sent by the caller
+	server.ApplyInvocationHeaders(server.InvocationHeaders{CallerAppID: true})
+	got = h()
+	got["dapr-deadline"] = tinygo.HeaderValues{"2000-01-01T00:00:00Z"}
+	server.SetInvocationHeaders(context.Background(), got)
+	assert.Equal(t, tinygo.HeaderMap{
+		"dapr-caller-app-id": {"checkout"},
+		"traceparent":        {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+	}, got)
+}
+
+// actorTransport keeps the request sent to the actor and answers 200.
+type actorTransport struct {
+	got tinygo.HttpRequest
+}
+
+func (a *actorTransport) Send(msg actor.Message) ([]byte, error) {
+	dec := msgpack.NewDecoder(msg.Arg)
+	req, err := tinygo.MDecodeHttpRequest(&dec)
 	if err != nil {
-		t.Errorf("Error starting server: %s", err)
+		return nil, err
+	}
+	a.got = req
+	return encode.Encode(&tinygo.HttpResponse{StatusCode: http.StatusOK, Header: tinygo.HeaderMap{}, Body: []byte("ok")})
+}
+
+// plain http clients can't name themselves as the caller on either listener.
+func TestHTTPInvocationHeaders(t *testing.T) {
+	conf := provider.ActorConfig{ActorID: "a", ActorConfig: map[string]string{"address": "0.0.0.0:8888", "unique_id": "a"}}
+	for name, newServer := range map[string]func(tp transport.Transport) http.Handler{
+		"http": func(tp transport.Transport) http.Handler { return httpserver.New(conf, tp, nil) },
+		"grpc": func(tp transport.Transport) http.Handler { return daprserver.New(conf, tp) },
+	} {
+		tp := &actorTransport{}
+		r := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader("{}"))
+		r.Header.Set("Dapr-Caller-App-Id", "checkout")
+		r.Header.Set("Dapr-Caller-Namespace", "shop")
+		r.Header.Set("Dapr-Deadline", "2000-01-01T00:00:00Z")
+		r.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		w := httptest.NewRecorder()
+		newServer(tp).ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code, name)
+		// the decoded header values start with an empty string
+		got := map[string]string{}
+		for k, v := range tp.got.Header {
+			got[k] = v[len(v)-1]
+		}
+		assert.Equal(t, map[string]string{
+			"Traceparent":   "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			"dapr-trace-id": "4bf92f3577b34da6a3ce929d0e0e4736",
+		}, got, name)
+	}
+}
+
+// the config of a listener can be updated while it starts.
+func TestUpdateWhileRunning(t *testing.T) {
+	conf := provider.ActorConfig{ActorID: "a", ActorConfig: map[string]string{"address": "127.0.0.1:0", "unique_id": "a"}}
+	for name, newServer := range map[string]func() server.HttpServerInterface{
+		"http": func() server.HttpServerInterface { return httpserver.New(conf, &actorTransport{}, nil) },
+		"grpc": func() server.HttpServerInterface { return daprserver.New(conf, &actorTransport{}) },
+	} {
+		s := newServer()
+		updated := provider.ActorConfig{ActorID: "a", ActorConfig: map[string]string{"address": "127.0.0.1:0", "unique_id": "b"}}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			s.Update(updated, &actorTransport{})
+		}()
+		require.NoError(t, s.Run(), name)
+		<-done
+		require.NoError(t, s.Shutdown(context.Background()), name)
 	}
-	time.Sleep(time.Second * 500)
 }
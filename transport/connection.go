@@ -0,0 +1,154 @@
+package transport
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+var (
+	ErrNotConnected     = errors.New("lattice connection is not available")
+	ErrConnectionClosed = errors.New("lattice connection is closed")
+	ErrQueueFull        = errors.New("too many requests waiting for the lattice connection")
+)
+
+type ConnectionState int
+
+const (
+	Connected ConnectionState = iota
+	Reconnecting
+	Closed
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case Connected:
+		return "connected"
+	case Reconnecting:
+		return "reconnecting"
+	default:
+		return "closed"
+	}
+}
+
+// QueueOptions controls what happens to dispatches while the lattice connection is down.
+type QueueOptions struct {
+	// Size is the maximum number of dispatches waiting for a reconnect, 0 fails them immediately.
+	Size int
+	// Timeout is how long a queued dispatch waits before giving up.
+	Timeout time.Duration
+}
+
+// ConnectionMonitor tracks the state of the nats connection shared by all transports.
+type ConnectionMonitor struct {
+	nc        *nats.Conn
+	opts      QueueOptions
+	l         sync.Mutex
+	state     ConnectionState
+	ready     chan struct{} // closed while connected
+	waiting   int32
+	listeners []func(ConnectionState)
+}
+
+func NewConnectionMonitor(nc *nats.Conn, opts QueueOptions) *ConnectionMonitor {
+	m := newConnectionMonitor(opts)
+	m.nc = nc
+	if nc == nil {
+		return m
+	}
+	if !nc.IsConnected() {
+		m.setState(Reconnecting)
+	}
+	nc.SetDisconnectErrHandler(func(_ *nats.Conn, err error) {
+		m.setState(Reconnecting)
+	})
+	nc.SetReconnectHandler(func(_ *nats.Conn) {
+		m.setState(Connected)
+	})
+	nc.SetClosedHandler(func(_ *nats.Conn) {
+		m.setState(Closed)
+	})
+	return m
+}
+
+func newConnectionMonitor(opts QueueOptions) *ConnectionMonitor {
+	ready := make(chan struct{})
+	close(ready)
+	return &ConnectionMonitor{opts: opts, state: Connected, ready: ready}
+}
+
+func (m *ConnectionMonitor) Conn() *nats.Conn {
+	return m.nc
+}
+
+func (m *ConnectionMonitor) State() ConnectionState {
+	m.l.Lock()
+	defer m.l.Unlock()
+	return m.state
+}
+
+// OnStateChange registers fn to be called every time the connection state changes.
+func (m *ConnectionMonitor) OnStateChange(fn func(ConnectionState)) {
+	m.l.Lock()
+	m.listeners = append(m.listeners, fn)
+	m.l.Unlock()
+}
+
+// WaitConnected returns once the connection is usable. While reconnecting the caller is queued,
+// bounded by QueueOptions, and gets an error if the connection does not come back in time.
+func (m *ConnectionMonitor) WaitConnected() error {
+	m.l.Lock()
+	state, ready := m.state, m.ready
+	m.l.Unlock()
+	switch state {
+	case Connected:
+		return nil
+	case Closed:
+		return ErrConnectionClosed
+	}
+	if m.opts.Size <= 0 {
+		return ErrNotConnected
+	}
+	if atomic.AddInt32(&m.waiting, 1) > int32(m.opts.Size) {
+		atomic.AddInt32(&m.waiting, -1)
+		return ErrQueueFull
+	}
+	defer atomic.AddInt32(&m.waiting, -1)
+
+	timer := time.NewTimer(m.opts.Timeout)
+	defer timer.Stop()
+	select {
+	case <-ready:
+		if m.State() == Closed {
+			return ErrConnectionClosed
+		}
+		return nil
+	case <-timer.C:
+		return ErrNotConnected
+	}
+}
+
+func (m *ConnectionMonitor) setState(state ConnectionState) {
+	m.l.Lock()
+	if m.state == state || m.state == Closed {
+		m.l.Unlock()
+		return
+	}
+	prev := m.state
+	m.state = state
+	if prev == Connected {
+		m.ready = make(chan struct{})
+	} else if state != Reconnecting {
+		// wake up everyone waiting, they re-check the state
+		close(m.ready)
+	}
+	listeners := append([]func(ConnectionState){}, m.listeners...)
+	m.l.Unlock()
+
+	for _, fn := range listeners {
+		fn(state)
+	}
+}
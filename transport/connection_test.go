@@ -0,0 +1,74 @@
+package transport
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaitConnected(t *testing.T) {
+	t.Run("connected returns immediately", func(t *testing.T) {
+		m := newConnectionMonitor(QueueOptions{})
+		assert.NoError(t, m.WaitConnected())
+	})
+
+	t.Run("no queue fails while reconnecting", func(t *testing.T) {
+		m := newConnectionMonitor(QueueOptions{})
+		m.setState(Reconnecting)
+		assert.ErrorIs(t, m.WaitConnected(), ErrNotConnected)
+	})
+
+	t.Run("queued call resumes after reconnect", func(t *testing.T) {
+		m := newConnectionMonitor(QueueOptions{Size: 1, Timeout: time.Second})
+		m.setState(Reconnecting)
+		errC := make(chan error)
+		go func() {
+			errC <- m.WaitConnected()
+		}()
+		time.Sleep(10 * time.Millisecond)
+		m.setState(Connected)
+		assert.NoError(t, <-errC)
+	})
+
+	t.Run("queued call times out", func(t *testing.T) {
+		m := newConnectionMonitor(QueueOptions{Size: 1, Timeout: 10 * time.Millisecond})
+		m.setState(Reconnecting)
+		assert.ErrorIs(t, m.WaitConnected(), ErrNotConnected)
+	})
+
+	t.Run("full queue rejects", func(t *testing.T) {
+		m := newConnectionMonitor(QueueOptions{Size: 1, Timeout: time.Second})
+		m.setState(Reconnecting)
+		go m.WaitConnected()
+		time.Sleep(10 * time.Millisecond)
+		assert.ErrorIs(t, m.WaitConnected(), ErrQueueFull)
+		m.setState(Connected)
+	})
+
+	t.Run("closed fails queued calls", func(t *testing.T) {
+		m := newConnectionMonitor(QueueOptions{Size: 1, Timeout: time.Second})
+		m.setState(Reconnecting)
+		errC := make(chan error)
+		go func() {
+			errC <- m.WaitConnected()
+		}()
+		time.Sleep(10 * time.Millisecond)
+		m.setState(Closed)
+		assert.ErrorIs(t, <-errC, ErrConnectionClosed)
+	})
+}
+
+func TestStateListeners(t *testing.T) {
+	m := newConnectionMonitor(QueueOptions{})
+	var states []ConnectionState
+	m.OnStateChange(func(s ConnectionState) {
+		states = append(states, s)
+	})
+	m.setState(Reconnecting)
+	m.setState(Reconnecting)
+	m.setState(Connected)
+	m.setState(Closed)
+	m.setState(Connected)
+	assert.Equal(t, []ConnectionState{Reconnecting, Connected, Closed}, states)
+}
@@ -15,8 +15,12 @@ import (
 	"github.com/nats-io/nats.go"
 	"github.com/vmihailenco/msgpack/v5"
 	"github.com/wasmcloud/actor-tinygo"
+
+	logging "github.com/taction/http-provider-go/log"
 )
 
+var log = logging.NewLogger("wasmcloud.transport")
+
 type Transport interface {
 	Send(msg actor.Message) ([]byte, error)
 }
@@ -28,18 +32,24 @@ type ProviderTransport struct {
 	Timeout        time.Duration
 	NatsConnection *nats.Conn
 	HostData       provider.HostData
+	Monitor        *ConnectionMonitor
 	//transport      Transport
 }
 
-func NewTransport(ld provider.LinkDefinition, nc *nats.Conn, hostData provider.HostData) *ProviderTransport {
-	return &ProviderTransport{LD: ld, NatsConnection: nc, HostData: hostData}
+func NewTransport(ld provider.LinkDefinition, m *ConnectionMonitor, hostData provider.HostData) *ProviderTransport {
+	return &ProviderTransport{LD: ld, NatsConnection: m.Conn(), HostData: hostData, Monitor: m}
 }
 
 func (s *ProviderTransport) Send(msg actor.Message) ([]byte, error) {
+	if err := s.Monitor.WaitConnected(); err != nil {
+		return nil, err
+	}
 	from := s.LD.ProviderEntity()
 	to := s.LD.ActorEntity()
 	//topic := rpcTopic(to, "default") // todo fix lattice
 	guid := GenGuid()
+	l := logging.WithLink(log, s.LD.ActorID, s.LD.Values["unique_id"]).WithFields(map[string]any{logging.FieldInvocationID: guid})
+	l.Debugf("invoking %s", msg.Method)
 	invocation := provider.Invocation{
 		Origin:        from,
 		Target:        to,
@@ -58,6 +68,7 @@ func (s *ProviderTransport) Send(msg actor.Message) ([]byte, error) {
 	subj := fmt.Sprintf("wasmbus.rpc.%s.%s", s.HostData.LatticeRPCPrefix, s.LD.ActorID)
 	res, err := s.NatsConnection.Request(subj, natsBody, 5*time.Second)
 	if err != nil {
+		l.Warnf("invoking %s failed: %s", msg.Method, err)
 		return nil, err
 	}
 	ir := provider.InvocationResponse{}